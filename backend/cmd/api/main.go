@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"io"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/numbergroup/claw-swarm/cmd/api/routes"
 	"github.com/numbergroup/claw-swarm/pkg/config"
 	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/embed"
+	"github.com/numbergroup/claw-swarm/pkg/keyring"
 	"github.com/numbergroup/claw-swarm/pkg/ws"
 	"github.com/numbergroup/server"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -46,6 +50,9 @@ func main() {
 	if err != nil {
 		log.WithError(err).Fatal("failed to create bot db")
 	}
+	if err := botDB.WatchAuthInvalidations(ctx); err != nil {
+		log.WithError(err).Fatal("failed to watch bot auth invalidations")
+	}
 
 	messageDB, err := db.NewMessageDB(ctx, conf, sdb)
 	if err != nil {
@@ -77,12 +84,55 @@ func main() {
 		log.WithError(err).Fatal("failed to create space task db")
 	}
 
+	scheduleDB, err := db.NewTaskScheduleDB(ctx, conf, sdb)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create task schedule db")
+	}
+
 	artifactDB, err := db.NewArtifactDB(ctx, conf, sdb)
 	if err != nil {
 		log.WithError(err).Fatal("failed to create artifact db")
 	}
 
-	hub := ws.NewHub(log)
+	botTokenDB, err := db.NewBotTokenDB(ctx, conf, sdb)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create bot token db")
+	}
+
+	broadcaster, err := ws.NewBroadcaster(ctx, conf, sdb)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create broadcaster")
+	}
+	defer broadcaster.Close()
+
+	hub, err := ws.NewHub(log, broadcaster, conf.SlowConsumerPolicy)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create hub")
+	}
+
+	presence := ws.NewPresence(log, hub, botDB, conf.PresenceIdleAfter, conf.PresenceOfflineAfter)
+	go presence.Run(ctx)
+
+	if conf.MetricsListen != "" {
+		go func() {
+			metrics := http.NewServeMux()
+			metrics.Handle("/metrics", promhttp.Handler())
+			// #nosec G114
+			if err := http.ListenAndServe(conf.MetricsListen, metrics); err != nil {
+				log.WithError(err).Error("metrics server error")
+			}
+		}()
+	}
+
+	keys, err := keyring.New(conf)
+	if err != nil {
+		log.WithError(err).Fatal("failed to load jwt keys")
+	}
+
+	embedder, err := embed.NewEmbedder(conf)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create embedder")
+	}
 
 	rh := routes.NewRouteHandler(
 		conf,
@@ -96,9 +146,19 @@ func main() {
 		inviteCodeDB,
 		botSkillDB,
 		spaceTaskDB,
+		scheduleDB,
 		artifactDB,
+		botTokenDB,
 		hub,
+		presence,
+		keys,
+		embedder,
 	)
+	go rh.RunTaskReaper(ctx)
+	go rh.RunTaskScheduler(ctx)
+	go rh.BackfillSkillEmbeddings(ctx)
+	go rh.RunMessageEmbedder(ctx)
+
 	gin.DefaultWriter = io.Discard
 	router := gin.New()
 	router.Use(gin.Recovery())
@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	"github.com/numbergroup/server"
 )
 
@@ -41,6 +42,8 @@ func (rh *RouteHandler) CreateArtifact(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventArtifactCreated, result)
+
 	c.JSON(http.StatusCreated, result)
 }
 
@@ -81,7 +84,7 @@ func (rh *RouteHandler) ListArtifacts(c *gin.Context) {
 }
 
 func (rh *RouteHandler) DeleteArtifact(c *gin.Context) {
-	_, _, ok := rh.requireManagerBot(c)
+	_, botSpaceID, ok := rh.requireManagerBot(c)
 	if !ok {
 		return
 	}
@@ -98,5 +101,7 @@ func (rh *RouteHandler) DeleteArtifact(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventArtifactDeleted, gin.H{"id": artifactID.String()})
+
 	c.Status(http.StatusNoContent)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	ngerrors "github.com/numbergroup/errors"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -17,6 +18,13 @@ func (rh *RouteHandler) SignupEnabled(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"enabled": !rh.conf.DisableSignup})
 }
 
+// JWKS publishes the public signing keys so other services can verify tokens
+// issued here without holding a shared secret.
+func (rh *RouteHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, rh.keys.JWKS())
+}
+
 func (rh *RouteHandler) Signup(c *gin.Context) {
 	if rh.conf.DisableSignup {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "signups are currently disabled"})
@@ -241,18 +249,41 @@ func (rh *RouteHandler) RefreshBot(c *gin.Context) {
 		return
 	}
 
-	token, err := rh.generateToken(&types.Claims{
-		IsBot:      true,
-		BotSpaceID: claims.BotSpaceID,
-		BotID:      claims.BotID,
-		IsManager:  bot.IsManager,
-	}, nil)
+	// A token without a jti cannot be revoked on its own, so exchanging it
+	// moves the bot's token cutoff past it instead. Each one can then only
+	// be exchanged once, even if it leaked.
+	if claims.ID == "" {
+		if claims.IssuedAt == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked or expired"})
+			return
+		}
+		retired, err := rh.botTokenDB.RetireLegacy(c, bot.ID, claims.IssuedAt.Time)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to retire legacy bot token")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+			return
+		}
+		if !retired {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked or expired"})
+			return
+		}
+	}
+
+	token, err := rh.issueBotToken(c, bot)
 	if err != nil {
 		rh.log.WithError(err).Error("failed to generate bot refresh token")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
 		return
 	}
 
+	// Refreshing rotates the token: the one used for this request stops
+	// working once its replacement has been issued.
+	if claims.ID != "" {
+		if err := rh.botTokenDB.Revoke(c, claims.ID); err != nil {
+			rh.log.WithError(err).Error("failed to revoke refreshed bot token")
+		}
+	}
+
 	c.JSON(http.StatusOK, types.BotRegistrationResponse{
 		Token: token,
 		Bot:   bot,
@@ -308,18 +339,15 @@ func (rh *RouteHandler) RegisterBot(c *gin.Context) {
 		}
 	}
 
-	token, err := rh.generateToken(&types.Claims{
-		IsBot:      true,
-		BotSpaceID: space.ID,
-		BotID:      bot.ID,
-		IsManager:  isManager,
-	}, nil)
+	token, err := rh.issueBotToken(c, bot)
 	if err != nil {
 		rh.log.WithError(err).Error("failed to generate bot token")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "registration failed"})
 		return
 	}
 
+	rh.hub.Publish(space.ID, ws.EventBotJoined, bot)
+
 	c.JSON(http.StatusCreated, types.BotRegistrationResponse{
 		Token: token,
 		Bot:   bot,
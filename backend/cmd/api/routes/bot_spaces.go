@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 )
 
 func (rh *RouteHandler) CreateBotSpace(c *gin.Context) {
@@ -144,13 +145,21 @@ func (rh *RouteHandler) UpdateBotSpace(c *gin.Context) {
 		desc = req.Description
 	}
 
-	updated, err := rh.botSpaceDB.Update(c, botSpaceID, name, desc)
+	requiresTaskReview := existing.RequiresTaskReview
+	if req.RequiresTaskReview != nil {
+		requiresTaskReview = *req.RequiresTaskReview
+	}
+
+	updated, err := rh.botSpaceDB.Update(c, botSpaceID, name, desc, requiresTaskReview)
 	if err != nil {
 		rh.log.WithError(err).Error("failed to update bot space")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to update bot space"})
 		return
 	}
 
+	// Bots subscribe to the same stream, so never send them the join codes.
+	rh.hub.Publish(botSpaceID, ws.EventSpaceUpdated, types.BotSpaceBasic{ID: updated.ID, Name: updated.Name})
+
 	c.JSON(http.StatusOK, updated)
 }
 
@@ -196,5 +205,13 @@ func (rh *RouteHandler) RegenerateJoinCodes(c *gin.Context) {
 		return
 	}
 
+	// Rotating join codes is how an owner locks a space down, so every bot
+	// that joined with the old codes has to register again.
+	if _, err := rh.botTokenDB.RevokeByBotSpaceID(c, botSpaceID); err != nil {
+		rh.log.WithError(err).Error("failed to revoke bot tokens")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke bot tokens"})
+		return
+	}
+
 	c.JSON(http.StatusOK, updated)
 }
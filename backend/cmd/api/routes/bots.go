@@ -2,9 +2,12 @@ package routes
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	ngerrors "github.com/numbergroup/errors"
 	"github.com/numbergroup/server"
 )
@@ -22,6 +25,10 @@ func (rh *RouteHandler) ListBots(c *gin.Context) {
 		return
 	}
 
+	for i := range bots {
+		bots[i].Presence = rh.presence.State(bots[i])
+	}
+
 	c.JSON(http.StatusOK, bots)
 }
 
@@ -53,9 +60,33 @@ func (rh *RouteHandler) GetBot(c *gin.Context) {
 		return
 	}
 
+	bot.Presence = rh.presence.State(bot)
 	c.JSON(http.StatusOK, bot)
 }
 
+// Heartbeat marks the calling bot as active. Bots without an open socket or
+// stream should call it more often than PRESENCE_IDLE_AFTER to stay online.
+func (rh *RouteHandler) Heartbeat(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	if !claims.IsBot {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only bots can send heartbeats"})
+		return
+	}
+
+	presence, err := rh.presence.Heartbeat(c, botSpaceID, claims.BotID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to record heartbeat")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to record heartbeat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, presence)
+}
+
 func (rh *RouteHandler) RemoveBot(c *gin.Context) {
 	_, botSpaceID, ok := rh.requireOwner(c)
 	if !ok {
@@ -90,12 +121,24 @@ func (rh *RouteHandler) RemoveBot(c *gin.Context) {
 		}
 	}
 
-	if err := rh.botDB.Delete(c, botID.String()); err != nil {
+	// Deleting the bot cascades to its token rows, so its tokens stop
+	// authenticating immediately.
+	released, err := rh.botDB.Delete(c, botID.String())
+	if err != nil {
 		rh.log.WithError(err).Error("failed to delete bot")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to remove bot"})
 		return
 	}
 
+	rh.presence.Forget(bot.ID)
+	rh.hub.Publish(botSpaceID, ws.EventBotRemoved, gin.H{"id": bot.ID})
+
+	for _, task := range released {
+		rh.hub.Publish(botSpaceID, ws.EventTaskReleased, task)
+		rh.postSystemMessage(c, botSpaceID, fmt.Sprintf(
+			"Task %q was released because %s was removed. It is available again.", task.Name, bot.Name))
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -140,6 +183,7 @@ func (rh *RouteHandler) AssignManager(c *gin.Context) {
 	}
 
 	bot.IsManager = true
+	rh.hub.Publish(botSpaceID, ws.EventBotUpdated, bot)
 	c.JSON(http.StatusOK, bot)
 }
 
@@ -181,6 +225,9 @@ func (rh *RouteHandler) RemoveManagerRole(c *gin.Context) {
 		rh.log.WithError(err).Error("failed to clear manager bot id")
 	}
 
+	bot.IsManager = false
+	rh.hub.Publish(botSpaceID, ws.EventBotUpdated, bot)
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -219,6 +266,7 @@ func (rh *RouteHandler) MuteBot(c *gin.Context) {
 	}
 
 	bot.IsMuted = true
+	rh.hub.Publish(botSpaceID, ws.EventBotUpdated, bot)
 	c.JSON(http.StatusOK, bot)
 }
 
@@ -256,5 +304,48 @@ func (rh *RouteHandler) UnmuteBot(c *gin.Context) {
 		return
 	}
 
+	bot.IsMuted = false
+	rh.hub.Publish(botSpaceID, ws.EventBotUpdated, bot)
+
 	c.Status(http.StatusNoContent)
 }
+
+// RevokeBotTokens invalidates every outstanding token for a bot. The bot has
+// to register again with a join code to get a new one.
+func (rh *RouteHandler) RevokeBotTokens(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireOwner(c)
+	if !ok {
+		return
+	}
+
+	botID, err := server.GetUUIDParam(c, "botId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid botId"})
+		return
+	}
+
+	bot, err := rh.botDB.GetByID(c, botID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "bot not found"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to get bot")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke tokens"})
+		return
+	}
+
+	if bot.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "bot not found"})
+		return
+	}
+
+	revoked, err := rh.botTokenDB.RevokeByBotID(c, bot.ID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to revoke bot tokens")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.RevokeTokensResponse{Revoked: revoked})
+}
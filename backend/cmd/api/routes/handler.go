@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
@@ -10,8 +11,11 @@ import (
 	gocachestore "github.com/eko/gocache/store/go_cache/v4"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/numbergroup/claw-swarm/pkg/config"
 	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/embed"
+	"github.com/numbergroup/claw-swarm/pkg/keyring"
 	"github.com/numbergroup/claw-swarm/pkg/types"
 	"github.com/numbergroup/claw-swarm/pkg/ws"
 	"github.com/numbergroup/server"
@@ -32,9 +36,15 @@ type RouteHandler struct {
 	inviteCodeDB  db.InviteCodeDB
 	botSkillDB    db.BotSkillDB
 	spaceTaskDB   db.SpaceTaskDB
+	scheduleDB    db.TaskScheduleDB
 	artifactDB    db.ArtifactDB
+	botTokenDB    db.BotTokenDB
+	keys          *keyring.Keyring
+	embedder      embed.Embedder
+	embedWake     chan struct{}
 	auth          *authMiddleware
 	hub           *ws.Hub
+	presence      *ws.Presence
 	msgCache      *libcache.Cache[types.MessageListResponse]
 }
 
@@ -50,8 +60,13 @@ func NewRouteHandler(
 	inviteCodeDB db.InviteCodeDB,
 	botSkillDB db.BotSkillDB,
 	spaceTaskDB db.SpaceTaskDB,
+	scheduleDB db.TaskScheduleDB,
 	artifactDB db.ArtifactDB,
+	botTokenDB db.BotTokenDB,
 	hub *ws.Hub,
+	presence *ws.Presence,
+	keys *keyring.Keyring,
+	embedder embed.Embedder,
 ) *RouteHandler {
 	gocacheClient := gocache.New(5*time.Second, 10*time.Second)
 	store := gocachestore.NewGoCache(gocacheClient)
@@ -70,14 +85,22 @@ func NewRouteHandler(
 		inviteCodeDB:  inviteCodeDB,
 		botSkillDB:    botSkillDB,
 		spaceTaskDB:   spaceTaskDB,
+		scheduleDB:    scheduleDB,
 		artifactDB:    artifactDB,
-		auth:          &authMiddleware{jwtSecret: []byte(conf.JWTSecret)},
+		botTokenDB:    botTokenDB,
+		keys:          keys,
+		embedder:      embedder,
+		embedWake:     make(chan struct{}, 1),
+		auth:          &authMiddleware{keys: keys, botTokenDB: botTokenDB, botDB: botDB, log: conf.GetLogger()},
 		hub:           hub,
+		presence:      presence,
 		msgCache:      msgCache,
 	}
 }
 
 func (rh *RouteHandler) ApplyRoutes(r *gin.Engine) {
+	r.GET("/.well-known/jwks.json", rh.JWKS)
+
 	api := r.Group("/api/v1")
 
 	{ // public auth
@@ -124,12 +147,20 @@ func (rh *RouteHandler) ApplyRoutes(r *gin.Engine) {
 		space.DELETE("/bots/:botId/manager", rh.RemoveManagerRole)
 		space.PUT("/bots/:botId/mute", rh.MuteBot)
 		space.DELETE("/bots/:botId/mute", rh.UnmuteBot)
+		space.POST("/bots/:botId/revoke-tokens", rh.RevokeBotTokens)
+
+		// presence
+		space.POST("/presence/heartbeat", rh.Heartbeat)
 
 		// messages
 		space.POST("/messages", rh.PostMessage)
 		space.GET("/messages", rh.ListMessages)
+		space.GET("/messages/search", rh.SearchMessages)
+		space.GET("/messages/recall", rh.RecallMessages)
 		space.GET("/messages/since/:messageId", rh.GetMessagesSince)
 		space.GET("/messages/ws", rh.SubscribeMessages)
+		space.GET("/messages/stream", rh.StreamMessages)
+		space.GET("/messages/wait", rh.WaitForMessages)
 
 		// statuses
 		space.GET("/statuses", rh.ListStatuses)
@@ -148,19 +179,42 @@ func (rh *RouteHandler) ApplyRoutes(r *gin.Engine) {
 		space.POST("/tasks", rh.CreateTask)
 		space.GET("/tasks", rh.ListTasks)
 		space.GET("/tasks/current", rh.GetCurrentTask)
+		space.GET("/tasks/:taskId", rh.GetTask)
+		space.POST("/tasks/next", rh.ClaimNextTask)
 		space.POST("/tasks/:taskId/accept", rh.AcceptTask)
 		space.POST("/tasks/:taskId/complete", rh.CompleteTask)
 		space.POST("/tasks/:taskId/block", rh.BlockTask)
+		space.POST("/tasks/:taskId/review/approve", rh.ApproveTaskReview)
+		space.POST("/tasks/:taskId/review/reject", rh.RejectTaskReview)
+		space.POST("/tasks/:taskId/unblock", rh.UnblockTask)
+		space.POST("/tasks/:taskId/cancel", rh.CancelTask)
+		space.POST("/tasks/:taskId/reassign", rh.ReassignTask)
+		space.POST("/tasks/:taskId/handoff", rh.HandoffTask)
+		space.POST("/tasks/:taskId/heartbeat", rh.HeartbeatTask)
 		space.POST("/tasks/:taskId/assign", rh.AssignTask)
+		space.POST("/tasks/:taskId/subtasks", rh.ProposeSubtask)
+		space.POST("/tasks/:taskId/approve", rh.ApproveSubtask)
+		space.POST("/tasks/:taskId/reject", rh.RejectSubtask)
+		space.GET("/tasks/:taskId/tree", rh.GetTaskTree)
+		space.GET("/tasks/:taskId/history", rh.GetTaskHistory)
+
+		// task schedules
+		space.POST("/schedules", rh.CreateTaskSchedule)
+		space.GET("/schedules", rh.ListTaskSchedules)
+		space.POST("/schedules/:scheduleId/pause", rh.PauseTaskSchedule)
+		space.POST("/schedules/:scheduleId/resume", rh.ResumeTaskSchedule)
+		space.DELETE("/schedules/:scheduleId", rh.DeleteTaskSchedule)
 
 		// artifacts
 		space.POST("/artifacts", rh.CreateArtifact)
 		space.GET("/artifacts", rh.ListArtifacts)
+		space.GET("/artifacts/search", rh.SearchArtifacts)
 		space.DELETE("/artifacts/:artifactId", rh.DeleteArtifact)
 
 		// skills
 		space.POST("/skills", rh.CreateBotSkill)
 		space.GET("/skills", rh.ListBotSkills)
+		space.GET("/skills/search", rh.SearchBotSkills)
 		space.PUT("/skills/:skillId", rh.UpdateBotSkill)
 		space.DELETE("/skills/:skillId", rh.DeleteBotSkill)
 	}
@@ -271,6 +325,31 @@ func (rh *RouteHandler) requireManagerBot(c *gin.Context) (*types.Claims, string
 	return claims, botSpaceID, true
 }
 
+// requireManagerOrOwner allows the space's manager bots and its owner.
+func (rh *RouteHandler) requireManagerOrOwner(c *gin.Context) (*types.Claims, string, bool) {
+	claims := rh.getClaims(c)
+	if claims == nil {
+		return nil, "", false
+	}
+	if claims.IsBot {
+		return rh.requireManagerBot(c)
+	}
+	return rh.requireOwner(c)
+}
+
+// requireManagerOrMember allows the space's manager bots and every user with
+// access to the space.
+func (rh *RouteHandler) requireManagerOrMember(c *gin.Context) (*types.Claims, string, bool) {
+	claims := rh.getClaims(c)
+	if claims == nil {
+		return nil, "", false
+	}
+	if claims.IsBot {
+		return rh.requireManagerBot(c)
+	}
+	return rh.requireSpaceAccess(c)
+}
+
 func (rh *RouteHandler) generateCode(n int) (string, error) {
 	b := make([]byte, n)
 	if _, err := rand.Read(b); err != nil {
@@ -286,6 +365,35 @@ func (rh *RouteHandler) generateToken(claims *types.Claims, expiration *time.Dur
 		claims.ExpiresAt = jwt.NewNumericDate(now.Add(*expiration))
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(rh.conf.JWTSecret))
+	return rh.keys.Sign(claims)
+}
+
+// issueBotToken signs an expiring token for bot and records its jti so the
+// token can be revoked.
+func (rh *RouteHandler) issueBotToken(ctx context.Context, bot types.Bot) (string, error) {
+	claims := &types.Claims{
+		IsBot:      true,
+		BotSpaceID: bot.BotSpaceID,
+		BotID:      bot.ID,
+		IsManager:  bot.IsManager,
+	}
+	claims.ID = uuid.New().String()
+
+	exp := rh.conf.BotJWTExpiration
+	token, err := rh.generateToken(claims, &exp)
+	if err != nil {
+		return "", err
+	}
+
+	err = rh.botTokenDB.Insert(ctx, types.BotToken{
+		ID:         claims.ID,
+		BotID:      bot.ID,
+		BotSpaceID: bot.BotSpaceID,
+		IssuedAt:   claims.IssuedAt.Time,
+		ExpiresAt:  claims.ExpiresAt.Time,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	"github.com/numbergroup/server"
 	ngerrors "github.com/numbergroup/errors"
 )
@@ -136,5 +137,7 @@ func (rh *RouteHandler) JoinBotSpace(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(member.BotSpaceID, ws.EventMemberJoined, member)
+
 	c.JSON(http.StatusOK, member)
 }
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	"github.com/numbergroup/server"
 )
 
@@ -41,5 +42,7 @@ func (rh *RouteHandler) RemoveMember(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventMemberRemoved, gin.H{"userId": userID.String()})
+
 	c.Status(http.StatusNoContent)
 }
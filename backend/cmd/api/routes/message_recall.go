@@ -0,0 +1,164 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numbergroup/claw-swarm/pkg/embed"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/server"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+// RecallMessages returns the space's past messages nearest in meaning to the
+// q parameter, closest first, so bots can pull relevant history into a
+// prompt without paging through all of it.
+func (rh *RouteHandler) RecallMessages(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit, err := server.GetIntQuery(c, "limit", rh.conf.MaxMessagesPerPage, 10)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	embeddings, err := rh.embed(c, []string{q})
+	if err != nil {
+		rh.log.WithError(err).Error("failed to embed message recall query")
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "failed to recall messages"})
+		return
+	}
+	if embed.IsZero(embeddings[0]) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "q has nothing to search for"})
+		return
+	}
+
+	messages, err := rh.messageDB.Recall(c, botSpaceID, pgvector.NewVector(embeddings[0]), limit)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to recall messages")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to recall messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MessageRecallResponse{
+		Messages: messages,
+		Count:    len(messages),
+	})
+}
+
+// wakeMessageEmbedder asks the message embedder to run now rather than at
+// its next tick.
+func (rh *RouteHandler) wakeMessageEmbedder() {
+	select {
+	case rh.embedWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunMessageEmbedder embeds new messages until ctx is done, whenever one is
+// posted on this replica and periodically to pick up the rest. Replicas may
+// all run it; an advisory lock lets only one of them embed at a time, and
+// the others leave what they were woken for to its next pass.
+func (rh *RouteHandler) RunMessageEmbedder(ctx context.Context) {
+	ticker := time.NewTicker(rh.conf.MessageEmbedInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-rh.embedWake:
+		}
+		_, err := rh.messageDB.WithEmbedderLock(ctx, rh.embedPendingMessages)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to embed messages")
+		}
+	}
+}
+
+// embedPendingMessages embeds every message which has not been embedded
+// yet. A batch the embedder rejects is retried one message at a time, so a
+// message it cannot embed is found and recorded rather than holding up the
+// rest forever. It stops at the first error and leaves the rest for the next
+// run.
+func (rh *RouteHandler) embedPendingMessages(ctx context.Context) error {
+	for {
+		failedBefore := time.Now().Add(-rh.conf.MessageEmbedRetryDelay)
+		messages, err := rh.messageDB.ListUnembedded(ctx, embedBatchSize, rh.conf.MessageEmbedAttempts, failedBefore)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		texts := make([]string, len(messages))
+		for i, msg := range messages {
+			texts[i] = msg.Content
+		}
+		embeddings, err := rh.embed(ctx, texts)
+		if err != nil {
+			if len(messages) == 1 {
+				return rh.recordEmbedFailure(ctx, messages[0], err)
+			}
+			for _, msg := range messages {
+				if err := rh.embedMessage(ctx, msg); err != nil {
+					return err
+				}
+			}
+		} else {
+			for i, msg := range messages {
+				if err := rh.setMessageEmbedding(ctx, msg, embeddings[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(messages) < embedBatchSize {
+			return nil
+		}
+	}
+}
+
+// embedMessage embeds a single message, recording the failure if the
+// embedder rejects it.
+func (rh *RouteHandler) embedMessage(ctx context.Context, msg types.Message) error {
+	embeddings, err := rh.embed(ctx, []string{msg.Content})
+	if err != nil {
+		return rh.recordEmbedFailure(ctx, msg, err)
+	}
+	return rh.setMessageEmbedding(ctx, msg, embeddings[0])
+}
+
+// recordEmbedFailure records that msg failed to embed with cause, which it
+// returns so the run stops; an embedder which is down fails every message.
+func (rh *RouteHandler) recordEmbedFailure(ctx context.Context, msg types.Message, cause error) error {
+	if err := rh.messageDB.RecordEmbedFailure(ctx, msg.ID); err != nil {
+		return err
+	}
+	return fmt.Errorf("failed to embed message %s: %w", msg.ID, cause)
+}
+
+// setMessageEmbedding stores the embedding of msg, or none if it had nothing
+// to embed.
+func (rh *RouteHandler) setMessageEmbedding(ctx context.Context, msg types.Message, embedding []float32) error {
+	if embed.IsZero(embedding) {
+		return rh.messageDB.SetEmbedding(ctx, msg.ID, nil)
+	}
+	vec := pgvector.NewVector(embedding)
+	return rh.messageDB.SetEmbedding(ctx, msg.ID, &vec)
+}
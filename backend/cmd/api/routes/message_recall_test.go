@@ -0,0 +1,240 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/embed"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+// storedMessage is a message with the embedding state the messages table
+// keeps for it.
+type storedMessage struct {
+	types.Message
+	embedded  bool
+	embedding *pgvector.Vector
+	attempts  int
+	failedAt  time.Time
+}
+
+// fakeMessageDB keeps messages in insertion order, which stands in for
+// creation order. Its rules mirror the SQL in pkg/db.
+type fakeMessageDB struct {
+	db.MessageDB
+	messages []*storedMessage
+	recalls  int
+}
+
+func (f *fakeMessageDB) add(content string) *storedMessage {
+	msg := &storedMessage{Message: types.Message{ID: uuid.New().String(), Content: content}}
+	f.messages = append(f.messages, msg)
+	return msg
+}
+
+func (f *fakeMessageDB) ListUnembedded(_ context.Context, limit int, maxAttempts int, failedBefore time.Time) ([]types.Message, error) {
+	messages := make([]types.Message, 0)
+	for _, msg := range f.messages {
+		if len(messages) == limit {
+			break
+		}
+		if msg.embedded || msg.attempts >= maxAttempts || (!msg.failedAt.IsZero() && !msg.failedAt.Before(failedBefore)) {
+			continue
+		}
+		messages = append(messages, msg.Message)
+	}
+	return messages, nil
+}
+
+func (f *fakeMessageDB) find(id string) *storedMessage {
+	for _, msg := range f.messages {
+		if msg.ID == id {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (f *fakeMessageDB) SetEmbedding(_ context.Context, id string, embedding *pgvector.Vector) error {
+	msg := f.find(id)
+	msg.embedded = true
+	msg.embedding = embedding
+	return nil
+}
+
+func (f *fakeMessageDB) RecordEmbedFailure(_ context.Context, id string) error {
+	msg := f.find(id)
+	msg.attempts++
+	msg.failedAt = time.Now()
+	return nil
+}
+
+func (f *fakeMessageDB) Recall(_ context.Context, _ string, _ pgvector.Vector, _ int) ([]types.RecalledMessage, error) {
+	f.recalls++
+	return []types.RecalledMessage{{Message: types.Message{ID: "recalled"}}}, nil
+}
+
+// fakeEmbedder hash-embeds texts, but rejects every request while down and
+// any request including a text containing "poison", as a provider does for
+// input it cannot handle. It keeps the texts it was asked to embed.
+type fakeEmbedder struct {
+	down  bool
+	texts []string
+}
+
+func (f *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	f.texts = append(f.texts, texts...)
+	if f.down {
+		return nil, errors.New("embedder unavailable")
+	}
+	for _, text := range texts {
+		if strings.Contains(text, "poison") {
+			return nil, errors.New("invalid input")
+		}
+	}
+	return embed.NewHashEmbedder().Embed(ctx, texts)
+}
+
+func newEmbedTest() (*RouteHandler, *fakeMessageDB, *fakeEmbedder) {
+	conf := &config.Config{
+		MessageEmbedAttempts:   2,
+		MessageEmbedRetryDelay: time.Hour,
+		EmbedderMaxInputChars:  100,
+		MaxMessagesPerPage:     30,
+	}
+	messageDB := &fakeMessageDB{}
+	embedder := &fakeEmbedder{}
+	rh := &RouteHandler{log: conf.GetLogger(), conf: conf, messageDB: messageDB, embedder: embedder}
+	return rh, messageDB, embedder
+}
+
+// TestEmbedPendingMessagesSkipsFailing embeds a backlog with a message the
+// embedder always rejects at its head. The rest must still be embedded, and
+// the rejected message given up on after its attempts run out.
+func TestEmbedPendingMessagesSkipsFailing(t *testing.T) {
+	rh, messageDB, _ := newEmbedTest()
+	ctx := context.Background()
+	poison := messageDB.add("poison")
+	first := messageDB.add("deploy the api")
+	empty := messageDB.add("?!")
+	last := messageDB.add("write release notes")
+
+	if err := rh.embedPendingMessages(ctx); err == nil {
+		t.Fatal("embedding a batch with a failing message succeeded, want its error")
+	}
+	if poison.attempts != 1 || poison.embedded {
+		t.Fatalf("got %d attempts for the failing message, embedded %v; want 1 and not embedded", poison.attempts, poison.embedded)
+	}
+
+	// The failed message waits out its retry delay while the rest embed.
+	if err := rh.embedPendingMessages(ctx); err != nil {
+		t.Fatalf("failed to embed messages: %v", err)
+	}
+	for _, msg := range []*storedMessage{first, last} {
+		if !msg.embedded || msg.embedding == nil {
+			t.Errorf("message %q was not embedded", msg.Content)
+		}
+	}
+	if !empty.embedded || empty.embedding != nil {
+		t.Errorf("got embedded %v with embedding %v for a message with nothing to embed, want embedded without one", empty.embedded, empty.embedding)
+	}
+
+	// Once its attempts run out the message is no longer tried.
+	rh.conf.MessageEmbedRetryDelay = 0
+	if err := rh.embedPendingMessages(ctx); err == nil {
+		t.Fatal("retrying the failing message succeeded, want its error")
+	}
+	if err := rh.embedPendingMessages(ctx); err != nil {
+		t.Fatalf("got %v after the failing message ran out of attempts, want nil", err)
+	}
+	if poison.attempts != 2 || poison.embedded {
+		t.Errorf("got %d attempts for the failing message, embedded %v; want 2 and not embedded", poison.attempts, poison.embedded)
+	}
+}
+
+// TestEmbedPendingMessagesEmbedderDown stops at the first message while the
+// embedder is down, charging an attempt to that message alone.
+func TestEmbedPendingMessagesEmbedderDown(t *testing.T) {
+	rh, messageDB, embedder := newEmbedTest()
+	embedder.down = true
+	first := messageDB.add("deploy the api")
+	second := messageDB.add("write release notes")
+
+	if err := rh.embedPendingMessages(context.Background()); err == nil {
+		t.Fatal("embedding with the embedder down succeeded, want an error")
+	}
+	if first.attempts != 1 || second.attempts != 0 {
+		t.Errorf("got attempts %d and %d, want 1 and 0", first.attempts, second.attempts)
+	}
+	if len(embedder.texts) != 3 {
+		t.Errorf("got %d texts sent to the embedder, want the batch of 2 then 1", len(embedder.texts))
+	}
+}
+
+func TestEmbedPendingMessagesTruncates(t *testing.T) {
+	rh, messageDB, embedder := newEmbedTest()
+	msg := messageDB.add(strings.Repeat("é", 250))
+
+	if err := rh.embedPendingMessages(context.Background()); err != nil {
+		t.Fatalf("failed to embed messages: %v", err)
+	}
+	if !msg.embedded {
+		t.Error("the long message was not embedded")
+	}
+	for _, text := range embedder.texts {
+		if got := len([]rune(text)); got > rh.conf.EmbedderMaxInputChars {
+			t.Errorf("sent the embedder %d characters, want at most %d", got, rh.conf.EmbedderMaxInputChars)
+		}
+	}
+}
+
+func TestRecallMessages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	botSpaceID := uuid.New().String()
+
+	tests := []struct {
+		name        string
+		q           string
+		down        bool
+		want        int
+		wantRecalls int
+	}{
+		{name: "recalls", q: "deploy the api", want: http.StatusOK, wantRecalls: 1},
+		{name: "missing query", q: " ", want: http.StatusBadRequest},
+		{name: "nothing to search for", q: "?!", want: http.StatusBadRequest},
+		{name: "embedder down", q: "deploy the api", down: true, want: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rh, messageDB, embedder := newEmbedTest()
+			embedder.down = tt.down
+
+			router := gin.New()
+			router.GET("/bot-spaces/:botSpaceId/messages/recall", func(c *gin.Context) {
+				c.Set("claims", &types.Claims{IsBot: true, BotSpaceID: botSpaceID, BotID: uuid.New().String()})
+			}, rh.RecallMessages)
+
+			req := httptest.NewRequest(http.MethodGet, "/bot-spaces/"+botSpaceID+"/messages/recall?q="+url.QueryEscape(tt.q), nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if messageDB.recalls != tt.wantRecalls {
+				t.Errorf("got %d recalls, want %d", messageDB.recalls, tt.wantRecalls)
+			}
+		})
+	}
+}
@@ -1,18 +1,26 @@
 package routes
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
+	ngerrors "github.com/numbergroup/errors"
 	"github.com/numbergroup/server"
 )
 
+const sseKeepAlive = 30 * time.Second
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
@@ -29,65 +37,175 @@ func (rh *RouteHandler) PostMessage(c *gin.Context) {
 		return
 	}
 
-	if len(req.Content) > rh.conf.MaxMessageLength {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "message too long"})
+	msg, cmdErr := rh.postMessage(c, claims, botSpaceID, req.Content)
+	if cmdErr != nil {
+		c.AbortWithStatusJSON(cmdErr.Status, gin.H{"error": cmdErr.Message})
 		return
 	}
 
-	var senderID, senderName, senderType string
+	c.JSON(http.StatusCreated, msg)
+}
+
+type messageSender struct {
+	ID   string
+	Name string
+	Type string
+}
+
+// resolveSender names the principal behind claims. Bots are looked up
+// through the cached auth state the middleware already loaded.
+func (rh *RouteHandler) resolveSender(ctx context.Context, claims *types.Claims) (messageSender, error) {
 	if claims.IsBot {
-		senderID = claims.BotID
-		senderType = "bot"
-		bot, err := rh.botDB.GetByID(c, claims.BotID)
-		if err != nil {
-			rh.log.WithError(err).Error("failed to get bot for sender name")
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to post message"})
-			return
-		}
-		if bot.IsMuted {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "bot is muted"})
-			return
-		}
-		senderName = bot.Name
-	} else {
-		senderID = claims.UserID
-		senderType = "user"
-		user, err := rh.userDB.GetByID(c, claims.UserID)
+		auth, err := rh.botDB.GetAuth(ctx, claims.BotID)
 		if err != nil {
-			rh.log.WithError(err).Error("failed to get user for sender name")
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to post message"})
-			return
-		}
-		if user.DisplayName != nil {
-			senderName = *user.DisplayName
-		} else {
-			senderName = user.Email
+			return messageSender{}, err
 		}
+		return messageSender{ID: claims.BotID, Name: auth.Name, Type: "bot"}, nil
+	}
+
+	user, err := rh.userDB.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return messageSender{}, err
+	}
+	name := user.Email
+	if user.DisplayName != nil {
+		name = *user.DisplayName
+	}
+	return messageSender{ID: claims.UserID, Name: name, Type: "user"}, nil
+}
+
+// postMessage validates, stores and publishes a chat message. It backs both
+// the REST endpoint and the post_message socket command.
+func (rh *RouteHandler) postMessage(ctx context.Context, claims *types.Claims, botSpaceID, content string) (types.Message, *ws.CommandError) {
+	if len(content) > rh.conf.MaxMessageLength {
+		return types.Message{}, &ws.CommandError{Status: http.StatusBadRequest, Code: "too_long", Message: "message too long"}
+	}
+
+	if claims.IsMuted {
+		return types.Message{}, &ws.CommandError{Status: http.StatusForbidden, Code: "muted", Message: "bot is muted"}
+	}
+
+	sender, err := rh.resolveSender(ctx, claims)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to resolve message sender")
+		return types.Message{}, &ws.CommandError{Status: http.StatusInternalServerError, Code: "internal", Message: "failed to post message"}
 	}
 
 	msg := types.Message{
 		ID:         uuid.New().String(),
 		BotSpaceID: botSpaceID,
-		SenderID:   senderID,
-		SenderName: senderName,
-		SenderType: senderType,
-		Content:    req.Content,
+		SenderID:   sender.ID,
+		SenderName: sender.Name,
+		SenderType: sender.Type,
+		Content:    content,
 		CreatedAt:  time.Now(),
 	}
 
-	_, err := rh.messageDB.Insert(c, msg)
-	if err != nil {
+	if _, err := rh.messageDB.Insert(ctx, msg); err != nil {
 		rh.log.WithError(err).Error("failed to insert message")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to post message"})
-		return
+		return types.Message{}, &ws.CommandError{Status: http.StatusInternalServerError, Code: "internal", Message: "failed to post message"}
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventMessageCreated, msg)
+	rh.wakeMessageEmbedder()
+	return msg, nil
+}
+
+// socketCommands handles the command frames a subscriber may send over its
+// WebSocket. claims are captured at upgrade time; the gin context is gone by
+// the time commands arrive, so each command checks them again first.
+func (rh *RouteHandler) socketCommands(upgradeClaims *types.Claims, botSpaceID string) ws.CommandHandler {
+	return func(ctx context.Context, cmd ws.Command) (any, error) {
+		claims, err := rh.revalidateSocketClaims(ctx, upgradeClaims, botSpaceID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch cmd.Type {
+		case ws.CommandPostMessage:
+			var req types.PostMessageRequest
+			if err := json.Unmarshal(cmd.Data, &req); err != nil || req.Content == "" {
+				return nil, &ws.CommandError{Status: http.StatusBadRequest, Code: "invalid_request", Message: "content is required"}
+			}
+			msg, cmdErr := rh.postMessage(ctx, claims, botSpaceID, req.Content)
+			if cmdErr != nil {
+				return nil, cmdErr
+			}
+			return msg, nil
+
+		case ws.CommandAck:
+			var req types.AckMessageRequest
+			if err := json.Unmarshal(cmd.Data, &req); err != nil {
+				return nil, &ws.CommandError{Status: http.StatusBadRequest, Code: "invalid_request", Message: "messageId is required"}
+			}
+			if _, err := uuid.Parse(req.MessageID); err != nil {
+				return nil, &ws.CommandError{Status: http.StatusBadRequest, Code: "invalid_request", Message: "invalid messageId"}
+			}
+			sender, err := rh.resolveSender(ctx, claims)
+			if err != nil {
+				return nil, err
+			}
+			ack := types.MessageAck{MessageID: req.MessageID, SenderID: sender.ID, SenderType: sender.Type}
+			rh.hub.Publish(botSpaceID, ws.EventMessageAcked, ack)
+			return ack, nil
+
+		case ws.CommandTyping:
+			if claims.IsMuted {
+				return nil, &ws.CommandError{Status: http.StatusForbidden, Code: "muted", Message: "bot is muted"}
+			}
+			sender, err := rh.resolveSender(ctx, claims)
+			if err != nil {
+				return nil, err
+			}
+			typing := types.TypingIndicator{SenderID: sender.ID, SenderName: sender.Name, SenderType: sender.Type}
+			rh.hub.Publish(botSpaceID, ws.EventTyping, typing)
+			return typing, nil
+
+		default:
+			return nil, &ws.CommandError{Status: http.StatusBadRequest, Code: "unknown_command", Message: "unknown command: " + cmd.Type}
+		}
 	}
+}
 
-	data, err := json.Marshal(msg)
-	if err == nil {
-		rh.hub.Broadcast(botSpaceID, data)
+// revalidateSocketClaims repeats the checks the auth middleware and
+// requireSpaceAccess made at upgrade time, returning claims refreshed with
+// the bot's live role and mute state. A principal which has since lost
+// access gets a ws.CodeAccessRevoked error, which closes the socket.
+func (rh *RouteHandler) revalidateSocketClaims(ctx context.Context, claims *types.Claims, botSpaceID string) (*types.Claims, error) {
+	if !claims.IsBot {
+		isMember, err := rh.spaceMemberDB.IsMember(ctx, botSpaceID, claims.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, &ws.CommandError{Status: http.StatusForbidden, Code: ws.CodeAccessRevoked, Message: "not a member of this space"}
+		}
+		return claims, nil
 	}
 
-	c.JSON(http.StatusCreated, msg)
+	active, err := rh.botTokenDB.IsActive(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, &ws.CommandError{Status: http.StatusUnauthorized, Code: ws.CodeAccessRevoked, Message: "token has been revoked or expired"}
+	}
+
+	auth, err := rh.botDB.GetAuth(ctx, claims.BotID)
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			return nil, &ws.CommandError{Status: http.StatusUnauthorized, Code: ws.CodeAccessRevoked, Message: "bot no longer exists"}
+		}
+		return nil, err
+	}
+	if tokenRevoked(claims, auth) {
+		return nil, &ws.CommandError{Status: http.StatusUnauthorized, Code: ws.CodeAccessRevoked, Message: "token has been revoked or expired"}
+	}
+
+	refreshed := *claims
+	refreshed.IsManager = auth.IsManager
+	refreshed.IsMuted = auth.IsMuted
+	return &refreshed, nil
 }
 
 func (rh *RouteHandler) ListMessages(c *gin.Context) {
@@ -186,20 +304,327 @@ func (rh *RouteHandler) GetMessagesSince(c *gin.Context) {
 }
 
 func (rh *RouteHandler) SubscribeMessages(c *gin.Context) {
-	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
 	if !ok {
 		return
 	}
 
+	var since string
+	if s := c.Query("since"); s != "" {
+		if _, err := uuid.Parse(s); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+		since = s
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		rh.log.WithError(err).Error("failed to upgrade websocket")
 		return
 	}
 
-	client := rh.hub.NewClient(conn, botSpaceID)
+	client := rh.hub.NewClient(conn, botSpaceID, ws.ParseEventFilter(c.Query("events")))
+	client.BotID = claims.BotID
+	client.Commands = rh.socketCommands(claims, botSpaceID)
+	client.ReadLimit = rh.conf.MaxBodySize
+	if since != "" {
+		// Register before reading history so anything posted while the replay
+		// runs is parked on the client rather than lost.
+		client.BeginReplay()
+	}
 	rh.hub.Register(client)
 
+	if since != "" {
+		if err := rh.replayMessages(c, client, botSpaceID, since, client.WriteReplay); err != nil {
+			rh.log.WithError(err).WithField("since", since).Warn("failed to replay messages")
+			rh.hub.Unregister(client)
+			client.CloseWithReason(ws.CloseReplayFailed, "replay failed; resync and reconnect without since")
+			return
+		}
+	}
+
 	go client.WritePump()
 	go client.ReadPump()
 }
+
+// replayMessages writes every message after since to the client through
+// write, up to the per-space retention limit, then hands it over to live
+// delivery.
+func (rh *RouteHandler) replayMessages(c *gin.Context, client *ws.Client, botSpaceID, since string, write func(ws.Event) error) error {
+	replayed := make(map[string]struct{})
+	cursor := since
+	for len(replayed) < rh.conf.MaxMessagesPerSpace {
+		messages, err := rh.messageDB.ListSince(c, botSpaceID, cursor, rh.conf.MaxMessagesPerPage)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range messages {
+			ev, err := ws.NewEvent(botSpaceID, ws.EventMessageCreated, msg)
+			if err != nil {
+				return err
+			}
+			ev.Replayed = true
+			if err := write(ev); err != nil {
+				return err
+			}
+			replayed[msg.ID] = struct{}{}
+		}
+
+		if len(messages) < rh.conf.MaxMessagesPerPage {
+			break
+		}
+		cursor = messages[len(messages)-1].ID
+	}
+
+	return client.EndReplay(replayed)
+}
+
+// StreamMessages serves the same feed as SubscribeMessages as Server-Sent
+// Events, for clients which cannot hold a WebSocket open. A since query or
+// Last-Event-ID header resumes after the given message id.
+func (rh *RouteHandler) StreamMessages(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	since := c.Query("since")
+	if since == "" {
+		since = c.GetHeader("Last-Event-ID")
+	}
+	if since != "" {
+		if _, err := uuid.Parse(since); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	client := rh.hub.NewSubscriber(botSpaceID, ws.ParseEventFilter(c.Query("events")))
+	client.BotID = claims.BotID
+	if since != "" {
+		client.BeginReplay()
+	}
+	rh.hub.Register(client)
+	defer rh.hub.Unregister(client)
+
+	if since != "" {
+		err := rh.replayMessages(c, client, botSpaceID, since, func(ev ws.Event) error {
+			data, err := client.Encode(ev)
+			if err != nil {
+				return err
+			}
+			return writeSSE(c.Writer, client, data)
+		})
+		if err != nil {
+			rh.log.WithError(err).WithField("since", since).Warn("failed to replay messages")
+			if err := writeSSEEvent(c.Writer, "", "error", []byte(`{"error":"replay failed; resync and reconnect without since"}`)); err != nil {
+				rh.log.WithError(err).Debug("failed to write sse error")
+			}
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case data, ok := <-client.Send:
+			if !ok {
+				if reason := client.DropReason(); reason != "" {
+					payload, _ := json.Marshal(gin.H{"error": reason + "; resync and reconnect"})
+					if err := writeSSEEvent(c.Writer, "", "error", payload); err != nil {
+						rh.log.WithError(err).Debug("failed to write sse error")
+					}
+				}
+				return
+			}
+			if err := writeSSE(c.Writer, client, data); err != nil {
+				return
+			}
+			if len(client.Send) == 0 {
+				if notice := client.TakeResyncNotice(); notice != nil {
+					if err := writeSSE(c.Writer, client, notice); err != nil {
+						return
+					}
+				}
+			}
+		case <-keepAlive.C:
+			if _, err := c.Writer.WriteString(": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// WaitForMessages long-polls for messages after since, holding the request
+// until one arrives or the timeout passes. Without since it returns the next
+// messages posted to the space.
+func (rh *RouteHandler) WaitForMessages(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	since := c.Query("since")
+	if since != "" {
+		if _, err := uuid.Parse(since); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+	}
+
+	limit, err := server.GetIntQuery(c, "limit", rh.conf.MaxMessagesPerPage, rh.conf.MaxMessagesPerPage)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	timeout := rh.conf.LongPollTimeout
+	if t := c.Query("timeout"); t != "" {
+		secs, err := strconv.Atoi(t)
+		if err != nil || secs < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid timeout"})
+			return
+		}
+		if d := time.Duration(secs) * time.Second; d < timeout {
+			timeout = d
+		}
+	}
+
+	// Subscribe before the first query so a message posted in between still
+	// wakes the request.
+	client := rh.hub.NewSubscriber(botSpaceID, nil)
+	rh.hub.Register(client)
+	defer rh.hub.Unregister(client)
+
+	if since != "" {
+		resp, err := rh.listSinceUncached(c, botSpaceID, since, limit)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to list messages since")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get messages"})
+			return
+		}
+		if resp.Count > 0 {
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var first []byte
+	select {
+	case data, ok := <-client.Send:
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "subscription dropped"})
+			return
+		}
+		first = data
+	case <-timer.C:
+		c.JSON(http.StatusOK, types.MessageListResponse{Messages: []types.Message{}})
+		return
+	case <-c.Request.Context().Done():
+		return
+	}
+
+	if since != "" {
+		resp, err := rh.listSinceUncached(c, botSpaceID, since, limit)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to list messages since")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get messages"})
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	messages := make([]types.Message, 0, 1)
+	for data := first; data != nil && len(messages) < limit; {
+		var msg types.Message
+		if err := json.Unmarshal(data, &msg); err == nil {
+			messages = append(messages, msg)
+		}
+		select {
+		case data = <-client.Send:
+		default:
+			data = nil
+		}
+	}
+
+	c.JSON(http.StatusOK, types.MessageListResponse{
+		Messages: messages,
+		Count:    len(messages),
+	})
+}
+
+func (rh *RouteHandler) listSinceUncached(c *gin.Context, botSpaceID, since string, limit int) (types.MessageListResponse, error) {
+	messages, err := rh.messageDB.ListSince(c, botSpaceID, since, limit+1)
+	if err != nil {
+		return types.MessageListResponse{}, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	return types.MessageListResponse{
+		Messages: messages,
+		Count:    len(messages),
+		HasMore:  hasMore,
+	}, nil
+}
+
+// writeSSE writes a hub payload as an SSE frame. Message frames carry the
+// message id so EventSource reconnects resume through Last-Event-ID.
+func writeSSE(w gin.ResponseWriter, client *ws.Client, data []byte) error {
+	var id string
+	event := ws.EventMessageCreated
+	if client.Events == nil {
+		var msg struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(data, &msg); err == nil {
+			id = msg.ID
+		}
+	} else {
+		var ev struct {
+			Type string `json:"type"`
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &ev); err == nil {
+			event = ev.Type
+			if ev.Type == ws.EventMessageCreated {
+				id = ev.Data.ID
+			}
+		}
+	}
+	return writeSSEEvent(w, id, event, data)
+}
+
+func writeSSEEvent(w gin.ResponseWriter, id, event string, data []byte) error {
+	var buf bytes.Buffer
+	if id != "" {
+		fmt.Fprintf(&buf, "id: %s\n", id)
+	}
+	fmt.Fprintf(&buf, "event: %s\ndata: %s\n\n", event, data)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"database/sql"
 	"net/http"
 	"strings"
 	"sync"
@@ -8,13 +9,26 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/keyring"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	ngerrors "github.com/numbergroup/errors"
+	"github.com/sirupsen/logrus"
 )
 
 var botLastSeenCache sync.Map // map[string]time.Time
 
+// botRefreshPath accepts bot tokens issued before tokens carried a jti, so
+// existing bots can exchange them for a tracked, expiring token. Exchanging
+// one, or revoking a bot's tokens, moves its token cutoff past them, so they
+// stop working there too.
+const botRefreshPath = "/api/v1/auth/bots/refresh"
+
 type authMiddleware struct {
-	jwtSecret []byte
+	keys       *keyring.Keyring
+	botTokenDB db.BotTokenDB
+	botDB      db.BotDB
+	log        logrus.Ext1FieldLogger
 }
 
 func (am *authMiddleware) Handle(c *gin.Context) {
@@ -34,24 +48,66 @@ func (am *authMiddleware) Handle(c *gin.Context) {
 	}
 
 	claims := &types.Claims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return am.jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, claims, am.keys.Keyfunc)
 	if err != nil || !token.Valid {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 		return
 	}
 
+	if claims.IsBot {
+		if claims.ID == "" {
+			if c.FullPath() != botRefreshPath {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "bot token must be refreshed"})
+				return
+			}
+		} else {
+			active, err := am.botTokenDB.IsActive(c, claims.ID)
+			if err != nil {
+				am.log.WithError(err).Error("failed to check bot token")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check token"})
+				return
+			}
+			if !active {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked or expired"})
+				return
+			}
+		}
+
+		auth, err := am.botDB.GetAuth(c, claims.BotID)
+		if err != nil {
+			if ngerrors.Cause(err) == sql.ErrNoRows {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "bot no longer exists"})
+				return
+			}
+			am.log.WithError(err).Error("failed to get bot auth")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check token"})
+			return
+		}
+		if tokenRevoked(claims, auth) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked or expired"})
+			return
+		}
+		claims.IsManager = auth.IsManager
+		claims.IsMuted = auth.IsMuted
+	}
+
 	c.Set("claims", claims)
 	c.Next()
 }
 
+// tokenRevoked reports whether a bot token was issued before the bot's token
+// cutoff. A token without an iat is treated as older than any cutoff.
+func tokenRevoked(claims *types.Claims, auth types.BotAuth) bool {
+	if auth.TokensRevokedBefore == nil {
+		return false
+	}
+	return claims.IssuedAt == nil || claims.IssuedAt.Before(*auth.TokensRevokedBefore)
+}
+
 func (rh *RouteHandler) trackBotLastSeen(c *gin.Context) {
 	claims, _ := c.Get("claims")
 	if cl, ok := claims.(*types.Claims); ok && cl.IsBot && cl.BotID != "" {
+		rh.presence.Seen(cl.BotSpaceID, cl.BotID)
 		now := time.Now()
 		if last, ok := botLastSeenCache.Load(cl.BotID); ok {
 			if now.Sub(last.(time.Time)) < 60*time.Second {
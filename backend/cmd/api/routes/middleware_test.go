@@ -0,0 +1,306 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/keyring"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+)
+
+const probePath = "/api/v1/probe"
+
+// tokenStore holds the bot and token state the fakes below share. Its rules
+// mirror the SQL in pkg/db, whose own tests cover the real statements.
+type tokenStore struct {
+	mu      sync.Mutex
+	bots    map[string]types.Bot
+	tokens  map[string]types.BotToken
+	cutoffs map[string]time.Time
+}
+
+type fakeBotDB struct {
+	db.BotDB
+	s *tokenStore
+}
+
+func (f fakeBotDB) GetByID(_ context.Context, id string) (types.Bot, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	bot, ok := f.s.bots[id]
+	if !ok {
+		return bot, sql.ErrNoRows
+	}
+	return bot, nil
+}
+
+func (f fakeBotDB) GetAuth(_ context.Context, id string) (types.BotAuth, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	bot, ok := f.s.bots[id]
+	if !ok {
+		return types.BotAuth{}, sql.ErrNoRows
+	}
+	auth := types.BotAuth{ID: bot.ID, BotSpaceID: bot.BotSpaceID, Name: bot.Name}
+	if cutoff, ok := f.s.cutoffs[id]; ok {
+		auth.TokensRevokedBefore = &cutoff
+	}
+	return auth, nil
+}
+
+type fakeBotTokenDB struct {
+	db.BotTokenDB
+	s *tokenStore
+}
+
+func (f fakeBotTokenDB) Insert(_ context.Context, token types.BotToken) error {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	f.s.tokens[token.ID] = token
+	return nil
+}
+
+func (f fakeBotTokenDB) IsActive(_ context.Context, id string) (bool, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	token, ok := f.s.tokens[id]
+	return ok && token.RevokedAt == nil && token.ExpiresAt.After(time.Now()), nil
+}
+
+func (f fakeBotTokenDB) Revoke(_ context.Context, id string) error {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if token, ok := f.s.tokens[id]; ok && token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+		f.s.tokens[id] = token
+	}
+	return nil
+}
+
+func (f fakeBotTokenDB) RevokeByBotID(_ context.Context, botID string) (int64, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	now := time.Now()
+	var revoked int64
+	for id, token := range f.s.tokens {
+		if token.BotID == botID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			f.s.tokens[id] = token
+			revoked++
+		}
+	}
+	if cutoff := now.Truncate(time.Second).Add(time.Second); cutoff.After(f.s.cutoffs[botID]) {
+		f.s.cutoffs[botID] = cutoff
+	}
+	return revoked, nil
+}
+
+func (f fakeBotTokenDB) RetireLegacy(_ context.Context, botID string, issuedAt time.Time) (bool, error) {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	cutoff := issuedAt.Truncate(time.Second).Add(time.Second)
+	if current, ok := f.s.cutoffs[botID]; ok && !current.Before(cutoff) {
+		return false, nil
+	}
+	f.s.cutoffs[botID] = cutoff
+	return true, nil
+}
+
+type fakeBotSpaceDB struct {
+	db.BotSpaceDB
+}
+
+func (fakeBotSpaceDB) GetByID(_ context.Context, id string) (types.BotSpace, error) {
+	return types.BotSpace{ID: id, Name: "space"}, nil
+}
+
+type authTest struct {
+	rh     *RouteHandler
+	router *gin.Engine
+	store  *tokenStore
+	bot    types.Bot
+}
+
+func newAuthTest(t *testing.T) authTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	conf := &config.Config{JWTSecret: "test-secret", BotJWTExpiration: time.Hour}
+	keys, err := keyring.New(conf)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	bot := types.Bot{ID: uuid.New().String(), BotSpaceID: uuid.New().String(), Name: "bot"}
+	store := &tokenStore{
+		bots:    map[string]types.Bot{bot.ID: bot},
+		tokens:  make(map[string]types.BotToken),
+		cutoffs: make(map[string]time.Time),
+	}
+	botDB := fakeBotDB{s: store}
+	botTokenDB := fakeBotTokenDB{s: store}
+	rh := &RouteHandler{
+		log:        conf.GetLogger(),
+		conf:       conf,
+		botDB:      botDB,
+		botSpaceDB: fakeBotSpaceDB{},
+		botTokenDB: botTokenDB,
+		keys:       keys,
+		auth:       &authMiddleware{keys: keys, botTokenDB: botTokenDB, botDB: botDB, log: conf.GetLogger()},
+	}
+
+	router := gin.New()
+	router.GET(probePath, rh.auth.Handle, func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST(botRefreshPath, rh.auth.Handle, rh.RefreshBot)
+	return authTest{rh: rh, router: router, store: store, bot: bot}
+}
+
+// token signs a bot token issued at iat, recording it as active when it has
+// a jti.
+func (a authTest) token(t *testing.T, jti string, iat time.Time) string {
+	t.Helper()
+	claims := &types.Claims{IsBot: true, BotSpaceID: a.bot.BotSpaceID, BotID: a.bot.ID}
+	claims.ID = jti
+	claims.IssuedAt = jwt.NewNumericDate(iat)
+	if jti != "" {
+		claims.ExpiresAt = jwt.NewNumericDate(iat.Add(time.Hour))
+		a.store.tokens[jti] = types.BotToken{ID: jti, BotID: a.bot.ID, BotSpaceID: a.bot.BotSpaceID, IssuedAt: iat, ExpiresAt: iat.Add(time.Hour)}
+	}
+	signed, err := a.rh.keys.Sign(claims)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func (a authTest) do(method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+func (a authTest) expect(t *testing.T, method, path, token string, want int) *httptest.ResponseRecorder {
+	t.Helper()
+	w := a.do(method, path, token)
+	if w.Code != want {
+		t.Fatalf("%s %s got status %d, want %d: %s", method, path, w.Code, want, w.Body.String())
+	}
+	return w
+}
+
+// refresh exchanges token and returns the replacement.
+func (a authTest) refresh(t *testing.T, token string) string {
+	t.Helper()
+	w := a.expect(t, http.MethodPost, botRefreshPath, token, http.StatusOK)
+	var resp types.BotRegistrationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode refresh response: %v", err)
+	}
+	return resp.Token
+}
+
+func TestAuthRevokeOne(t *testing.T) {
+	a := newAuthTest(t)
+	jti := uuid.New().String()
+	token := a.token(t, jti, time.Now())
+	other := a.token(t, uuid.New().String(), time.Now())
+
+	a.expect(t, http.MethodGet, probePath, token, http.StatusOK)
+	if err := a.rh.botTokenDB.Revoke(context.Background(), jti); err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+	a.expect(t, http.MethodGet, probePath, token, http.StatusUnauthorized)
+	a.expect(t, http.MethodGet, probePath, other, http.StatusOK)
+}
+
+func TestAuthRevokeAll(t *testing.T) {
+	a := newAuthTest(t)
+	tracked := a.token(t, uuid.New().String(), time.Now())
+	legacy := a.token(t, "", time.Now().Add(-time.Hour))
+
+	if _, err := a.rh.botTokenDB.RevokeByBotID(context.Background(), a.bot.ID); err != nil {
+		t.Fatalf("failed to revoke tokens: %v", err)
+	}
+	a.expect(t, http.MethodGet, probePath, tracked, http.StatusUnauthorized)
+	a.expect(t, http.MethodPost, botRefreshPath, tracked, http.StatusUnauthorized)
+	a.expect(t, http.MethodPost, botRefreshPath, legacy, http.StatusUnauthorized)
+}
+
+// TestAuthCutoffSameSecond checks the cutoff alone, as a replica which
+// still has a token cached as active relies on it.
+func TestAuthCutoffSameSecond(t *testing.T) {
+	a := newAuthTest(t)
+	revokedAt := time.Now()
+	a.store.cutoffs[a.bot.ID] = revokedAt.Truncate(time.Second).Add(time.Second)
+
+	tests := []struct {
+		name string
+		iat  time.Time
+		want int
+	}{
+		{name: "earlier second", iat: revokedAt.Add(-time.Second), want: http.StatusUnauthorized},
+		{name: "start of the same second", iat: revokedAt.Truncate(time.Second), want: http.StatusUnauthorized},
+		{name: "same instant", iat: revokedAt, want: http.StatusUnauthorized},
+		{name: "next second", iat: revokedAt.Truncate(time.Second).Add(time.Second), want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.expect(t, http.MethodGet, probePath, a.token(t, uuid.New().String(), tt.iat), tt.want)
+		})
+	}
+}
+
+func TestAuthRefreshRotatesToken(t *testing.T) {
+	a := newAuthTest(t)
+	token := a.token(t, uuid.New().String(), time.Now())
+
+	refreshed := a.refresh(t, token)
+	a.expect(t, http.MethodGet, probePath, refreshed, http.StatusOK)
+	a.expect(t, http.MethodGet, probePath, token, http.StatusUnauthorized)
+	a.expect(t, http.MethodPost, botRefreshPath, token, http.StatusUnauthorized)
+}
+
+func TestAuthRefreshLegacyToken(t *testing.T) {
+	a := newAuthTest(t)
+	issuedAt := time.Now().Add(-24 * time.Hour)
+	legacy := a.token(t, "", issuedAt)
+	older := a.token(t, "", issuedAt.Add(-time.Hour))
+
+	// Legacy tokens only work for the exchange.
+	a.expect(t, http.MethodGet, probePath, legacy, http.StatusUnauthorized)
+
+	refreshed := a.refresh(t, legacy)
+	a.expect(t, http.MethodGet, probePath, refreshed, http.StatusOK)
+
+	// The exchange retires the token and every legacy token issued before
+	// it, so a leaked copy cannot mint more tokens.
+	a.expect(t, http.MethodPost, botRefreshPath, legacy, http.StatusUnauthorized)
+	a.expect(t, http.MethodPost, botRefreshPath, older, http.StatusUnauthorized)
+
+	// The tracked replacement still refreshes normally.
+	a.expect(t, http.MethodGet, probePath, a.refresh(t, refreshed), http.StatusOK)
+}
+
+func TestAuthRefreshLegacyTokenWithoutIat(t *testing.T) {
+	a := newAuthTest(t)
+	claims := &types.Claims{IsBot: true, BotSpaceID: a.bot.BotSpaceID, BotID: a.bot.ID}
+	token, err := a.rh.keys.Sign(claims)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	a.expect(t, http.MethodPost, botRefreshPath, token, http.StatusUnauthorized)
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/server"
+)
+
+// SearchMessages returns the space's messages matching the q parameter, best
+// ranked first, paged by limit and offset.
+func (rh *RouteHandler) SearchMessages(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	q, limit, offset, ok := rh.searchParams(c)
+	if !ok {
+		return
+	}
+
+	messages, err := rh.messageDB.Search(c, botSpaceID, q, limit+1, offset)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to search messages")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to search messages"})
+		return
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	c.JSON(http.StatusOK, types.MessageSearchResponse{
+		Messages: messages,
+		Count:    len(messages),
+		HasMore:  hasMore,
+	})
+}
+
+// SearchArtifacts returns the space's artifacts matching the q parameter,
+// best ranked first, paged by limit and offset.
+func (rh *RouteHandler) SearchArtifacts(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	q, limit, offset, ok := rh.searchParams(c)
+	if !ok {
+		return
+	}
+
+	artifacts, err := rh.artifactDB.Search(c, botSpaceID, q, limit+1, offset)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to search artifacts")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to search artifacts"})
+		return
+	}
+
+	hasMore := len(artifacts) > limit
+	if hasMore {
+		artifacts = artifacts[:limit]
+	}
+
+	c.JSON(http.StatusOK, types.ArtifactSearchResponse{
+		Artifacts: artifacts,
+		Count:     len(artifacts),
+		HasMore:   hasMore,
+	})
+}
+
+// searchParams reads the query, which takes web search syntax such as
+// quoted phrases, "or" and -excluded words, and the page of a search.
+func (rh *RouteHandler) searchParams(c *gin.Context) (string, int, int, bool) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return "", 0, 0, false
+	}
+
+	limit, err := server.GetIntQuery(c, "limit", rh.conf.MaxMessagesPerPage, rh.conf.MaxMessagesPerPage)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", 0, 0, false
+	}
+
+	offset, err := server.GetIntQuery(c, "offset", -1, 0)
+	if err != nil || offset < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return "", 0, 0, false
+	}
+
+	return q, limit, offset, true
+}
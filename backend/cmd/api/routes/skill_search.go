@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numbergroup/claw-swarm/pkg/embed"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/server"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+const (
+	// skillSearchLimit is the default and maximum number of search results.
+	skillSearchLimit = 50
+	// embedBatchSize caps how many skills the backfill embeds per request.
+	embedBatchSize = 100
+)
+
+// SearchBotSkills returns the skills of the space nearest in meaning to the
+// q parameter, closest first.
+func (rh *RouteHandler) SearchBotSkills(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit, err := server.GetIntQuery(c, "limit", skillSearchLimit, 10)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	embeddings, err := rh.embed(c, []string{q})
+	if err != nil {
+		rh.log.WithError(err).Error("failed to embed skill search query")
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "failed to search skills"})
+		return
+	}
+	if embed.IsZero(embeddings[0]) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "q has nothing to search for"})
+		return
+	}
+
+	matches, err := rh.botSkillDB.Search(c, botSpaceID, pgvector.NewVector(embeddings[0]), limit)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to search bot skills")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to search skills"})
+		return
+	}
+
+	c.JSON(http.StatusOK, matches)
+}
+
+// embed embeds texts, cut to the provider's input limit, failing unless the
+// embedder returned one embedding per text.
+func (rh *RouteHandler) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	truncated := make([]string, len(texts))
+	for i, text := range texts {
+		truncated[i] = embed.Truncate(text, rh.conf.EmbedderMaxInputChars)
+	}
+	embeddings, err := rh.embedder.Embed(ctx, truncated)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedder returned %d embeddings for %d texts", len(embeddings), len(texts))
+	}
+	return embeddings, nil
+}
+
+// embedSkill returns the embedding of skill, or nil if the embedder fails.
+// Skills left without one are embedded by the next backfill.
+func (rh *RouteHandler) embedSkill(ctx context.Context, skill types.BotSkill) *pgvector.Vector {
+	embeddings, err := rh.embed(ctx, []string{skillText(skill)})
+	if err != nil {
+		rh.log.WithError(err).WithField("skillID", skill.ID).Warn("failed to embed bot skill")
+		return nil
+	}
+	if embed.IsZero(embeddings[0]) {
+		return nil
+	}
+	embedding := pgvector.NewVector(embeddings[0])
+	return &embedding
+}
+
+// skillText is the text a skill is embedded from.
+func skillText(skill types.BotSkill) string {
+	text := skill.Name + "\n" + skill.Description
+	if len(skill.Tags) > 0 {
+		text += "\n" + strings.Join(skill.Tags, ", ")
+	}
+	return text
+}
+
+// BackfillSkillEmbeddings embeds every skill which has no embedding yet,
+// such as those created before skills were embedded or while the embedder
+// was failing. Skills with nothing to embed are left without one. It stops
+// at the first embedder error, or once a batch embeds no skill.
+func (rh *RouteHandler) BackfillSkillEmbeddings(ctx context.Context) {
+	for {
+		skills, err := rh.botSkillDB.ListUnembedded(ctx, embedBatchSize)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to list unembedded bot skills")
+			return
+		}
+		if len(skills) == 0 {
+			return
+		}
+
+		texts := make([]string, len(skills))
+		for i, skill := range skills {
+			texts[i] = skillText(skill)
+		}
+		embeddings, err := rh.embed(ctx, texts)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to embed bot skills")
+			return
+		}
+
+		embedded := 0
+		for i, skill := range skills {
+			if embed.IsZero(embeddings[i]) {
+				continue
+			}
+			if err := rh.botSkillDB.SetEmbedding(ctx, skill.ID, pgvector.NewVector(embeddings[i])); err != nil {
+				rh.log.WithError(err).Error("failed to set bot skill embedding")
+				return
+			}
+			embedded++
+		}
+
+		if len(skills) < embedBatchSize || embedded == 0 {
+			return
+		}
+	}
+}
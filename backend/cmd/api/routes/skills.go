@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	ngerrors "github.com/numbergroup/errors"
 	"github.com/numbergroup/server"
 )
@@ -50,6 +51,7 @@ func (rh *RouteHandler) CreateBotSkill(c *gin.Context) {
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
+	skill.Embedding = rh.embedSkill(c, skill)
 
 	result, err := rh.botSkillDB.Insert(c, skill)
 	if err != nil {
@@ -62,6 +64,8 @@ func (rh *RouteHandler) CreateBotSkill(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventSkillCreated, result)
+
 	c.JSON(http.StatusCreated, result)
 }
 
@@ -138,6 +142,7 @@ func (rh *RouteHandler) UpdateBotSkill(c *gin.Context) {
 	}
 	existing.BotName = bot.Name
 	existing.UpdatedAt = time.Now()
+	existing.Embedding = rh.embedSkill(c, existing)
 
 	result, err := rh.botSkillDB.Update(c, existing)
 	if err != nil {
@@ -150,6 +155,8 @@ func (rh *RouteHandler) UpdateBotSkill(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(result.BotSpaceID, ws.EventSkillUpdated, result)
+
 	c.JSON(http.StatusOK, result)
 }
 
@@ -192,5 +199,7 @@ func (rh *RouteHandler) DeleteBotSkill(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(existing.BotSpaceID, ws.EventSkillDeleted, gin.H{"id": existing.ID})
+
 	c.Status(http.StatusNoContent)
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	ngerrors "github.com/numbergroup/errors"
 	"github.com/numbergroup/server"
 )
@@ -102,6 +103,8 @@ func (rh *RouteHandler) UpdateBotStatus(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventStatusUpdated, result)
+
 	c.JSON(http.StatusOK, result)
 }
 
@@ -150,5 +153,9 @@ func (rh *RouteHandler) BulkUpdateStatuses(c *gin.Context) {
 		return
 	}
 
+	for _, result := range results {
+		rh.hub.Publish(botSpaceID, ws.EventStatusUpdated, result)
+	}
+
 	c.JSON(http.StatusOK, results)
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	ngerrors "github.com/numbergroup/errors"
 )
 
@@ -66,5 +67,7 @@ func (rh *RouteHandler) UpdateSummary(c *gin.Context) {
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventSummaryUpdated, result)
+
 	c.JSON(http.StatusOK, result)
 }
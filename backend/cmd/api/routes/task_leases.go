@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
+	ngerrors "github.com/numbergroup/errors"
+	"github.com/numbergroup/server"
+)
+
+// reapBatchSize caps how many expired tasks a single reaper pass releases.
+const reapBatchSize = 100
+
+// taskLease returns when a lease taken or renewed at now expires.
+func (rh *RouteHandler) taskLease(now time.Time) *time.Time {
+	expiresAt := now.Add(rh.conf.TaskLeaseDuration)
+	return &expiresAt
+}
+
+// HeartbeatTask renews the lease the calling bot holds on its task.
+func (rh *RouteHandler) HeartbeatTask(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	if !claims.IsBot {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only bots can send task heartbeats"})
+		return
+	}
+
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
+		return
+	}
+
+	task, err := rh.spaceTaskDB.RenewLease(c, taskID.String(), claims.BotID, *rh.taskLease(time.Now()))
+	if err != nil {
+		if ngerrors.Cause(err) == db.ErrTaskConflict {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "you do not hold this task"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to renew task lease")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to renew task lease"})
+		return
+	}
+	if task.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+
+	rh.presence.Seen(botSpaceID, claims.BotID)
+
+	c.JSON(http.StatusOK, task)
+}
+
+// RunTaskReaper periodically returns tasks whose lease has lapsed to
+// available until ctx is done. Replicas may run it concurrently; each task
+// is released by only one of them.
+func (rh *RouteHandler) RunTaskReaper(ctx context.Context) {
+	ticker := time.NewTicker(rh.conf.TaskReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rh.reapExpiredTasks(ctx)
+		}
+	}
+}
+
+func (rh *RouteHandler) reapExpiredTasks(ctx context.Context) {
+	for {
+		released, err := rh.spaceTaskDB.ReleaseExpired(ctx, reapBatchSize)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to release expired tasks")
+			return
+		}
+
+		for _, task := range released {
+			rh.hub.Publish(task.BotSpaceID, ws.EventTaskReleased, task.SpaceTask)
+
+			botName := "a bot"
+			if task.ReleasedBotID != nil {
+				bot, err := rh.botDB.GetByID(ctx, *task.ReleasedBotID)
+				if err == nil {
+					botName = bot.Name
+					rh.updateBotStatusForTask(ctx, task.BotSpaceID, bot.ID, bot.Name, bot.ID, "")
+				}
+			}
+
+			rh.postSystemMessage(ctx, task.BotSpaceID, fmt.Sprintf(
+				"Task %q was released because %s stopped sending heartbeats. It is available again.", task.Name, botName))
+		}
+
+		if len(released) < reapBatchSize {
+			return
+		}
+	}
+}
+
+// postSystemMessage posts content to the space on behalf of the server.
+func (rh *RouteHandler) postSystemMessage(ctx context.Context, botSpaceID, content string) {
+	msg := types.Message{
+		ID:         uuid.New().String(),
+		BotSpaceID: botSpaceID,
+		SenderID:   botSpaceID,
+		SenderName: "system",
+		SenderType: "system",
+		Content:    content,
+		CreatedAt:  time.Now(),
+	}
+
+	if _, err := rh.messageDB.Insert(ctx, msg); err != nil {
+		rh.log.WithError(err).Error("failed to insert system message")
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventMessageCreated, msg)
+	rh.wakeMessageEmbedder()
+}
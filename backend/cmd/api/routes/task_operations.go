@@ -0,0 +1,279 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
+	ngerrors "github.com/numbergroup/errors"
+	"github.com/numbergroup/server"
+)
+
+// UnblockTask puts a blocked task back in progress with its bot, or back up
+// for grabs if that bot is gone or busy with another task.
+func (rh *RouteHandler) UnblockTask(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireManagerOrOwner(c)
+	if !ok {
+		return
+	}
+
+	var req types.TaskNoteRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
+	task, ok := rh.loadTask(c, botSpaceID, "failed to unblock task")
+	if !ok {
+		return
+	}
+	if task.Status != types.TaskStatusBlocked {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not blocked"})
+		return
+	}
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
+	now := time.Now()
+	fromStatus := task.Status
+	if task.BotID != nil {
+		task.Status = types.TaskStatusInProgress
+		task.LeaseExpiresAt = rh.taskLease(now)
+	} else {
+		task.Status = types.TaskStatusAvailable
+	}
+	task.UpdatedAt = now
+
+	taskEvent := newTaskEvent(claims, task, types.TaskEventUnblocked, fromStatus)
+	taskEvent.Note = optionalText(req.Note)
+
+	result, err := rh.spaceTaskDB.Update(c, task, taskEvent)
+	if ngerrors.Cause(err) == db.ErrBotBusy {
+		// The bot took other work while this task was blocked; let someone
+		// else pick it up.
+		task.Status = types.TaskStatusAvailable
+		task.BotID = nil
+		task.LeaseExpiresAt = nil
+		taskEvent = newTaskEvent(claims, task, types.TaskEventUnblocked, fromStatus)
+		taskEvent.Note = optionalText(req.Note)
+		result, err = rh.spaceTaskDB.Update(c, task, taskEvent)
+	}
+	if err != nil {
+		rh.abortTaskWrite(c, err, "failed to unblock task")
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventTaskUnblocked, result)
+
+	if result.BotID != nil {
+		rh.setTaskBotStatus(c, botSpaceID, *result.BotID, "Working on "+result.Name)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CancelTask stops work on a task for good. Tasks waiting only on it are
+// unlocked rather than left waiting forever.
+func (rh *RouteHandler) CancelTask(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireManagerOrMember(c)
+	if !ok {
+		return
+	}
+
+	var req types.TaskNoteRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
+	task, ok := rh.loadTask(c, botSpaceID, "failed to cancel task")
+	if !ok {
+		return
+	}
+	switch task.Status {
+	case types.TaskStatusCompleted, types.TaskStatusRejected, types.TaskStatusCancelled:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is already finished"})
+		return
+	}
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
+	fromStatus := task.Status
+	task.Status = types.TaskStatusCancelled
+	task.LeaseExpiresAt = nil
+	task.UpdatedAt = time.Now()
+
+	taskEvent := newTaskEvent(claims, task, types.TaskEventCancelled, fromStatus)
+	taskEvent.Note = optionalText(req.Note)
+
+	result, unlocked, err := rh.spaceTaskDB.Finish(c, task, taskEvent)
+	if err != nil {
+		rh.abortTaskWrite(c, err, "failed to cancel task")
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventTaskCancelled, result)
+	rh.publishUnlocked(botSpaceID, unlocked)
+
+	if fromStatus == types.TaskStatusInProgress && result.BotID != nil {
+		rh.setTaskBotStatus(c, botSpaceID, *result.BotID, "")
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ReassignTask moves a task which is in progress or blocked to another bot,
+// which starts working on it straight away.
+func (rh *RouteHandler) ReassignTask(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireManagerOrOwner(c)
+	if !ok {
+		return
+	}
+
+	var req types.ReassignTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, ok := rh.loadTask(c, botSpaceID, "failed to reassign task")
+	if !ok {
+		return
+	}
+	if task.Status != types.TaskStatusInProgress && task.Status != types.TaskStatusBlocked {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "only tasks in progress or blocked can be reassigned"})
+		return
+	}
+
+	rh.moveTask(c, claims, botSpaceID, task, req.BotID, types.TaskEventReassigned, ws.EventTaskReassigned, req.Note, "failed to reassign task")
+}
+
+// HandoffTask lets the bot working on a task pass it to another bot, with a
+// note on where it left off.
+func (rh *RouteHandler) HandoffTask(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	if !claims.IsBot {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only bots can hand off tasks"})
+		return
+	}
+
+	var req types.HandoffTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, ok := rh.loadTask(c, botSpaceID, "failed to hand off task")
+	if !ok {
+		return
+	}
+	if task.Status != types.TaskStatusInProgress {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not in progress"})
+		return
+	}
+	if task.BotID == nil || *task.BotID != claims.BotID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "you are not assigned to this task"})
+		return
+	}
+
+	rh.moveTask(c, claims, botSpaceID, task, req.BotID, types.TaskEventHandedOff, ws.EventTaskHandedOff, req.Note, "failed to hand off task")
+}
+
+// moveTask puts task in progress with the bot targetBotID, keeping the status
+// of both the previous and the new bot in sync.
+func (rh *RouteHandler) moveTask(c *gin.Context, claims *types.Claims, botSpaceID string, task types.SpaceTask, targetBotID, eventType, event, note, failure string) {
+	if task.BotID != nil && *task.BotID == targetBotID {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "task is already assigned to that bot"})
+		return
+	}
+
+	target, err := rh.botDB.GetByID(c, targetBotID)
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "target bot not found"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to get bot")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return
+	}
+	if target.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bot does not belong to this space"})
+		return
+	}
+
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
+	now := time.Now()
+	fromStatus := task.Status
+	previousBotID := task.BotID
+	task.Status = types.TaskStatusInProgress
+	task.BotID = &target.ID
+	task.LeaseExpiresAt = rh.taskLease(now)
+	task.UpdatedAt = now
+
+	taskEvent := newTaskEvent(claims, task, eventType, fromStatus)
+	taskEvent.Note = optionalText(note)
+
+	result, err := rh.spaceTaskDB.Update(c, task, taskEvent)
+	if err != nil {
+		rh.abortTaskWrite(c, err, failure)
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, event, result)
+
+	if previousBotID != nil && fromStatus == types.TaskStatusInProgress {
+		rh.setTaskBotStatus(c, botSpaceID, *previousBotID, "")
+	}
+	rh.updateBotStatusForTask(c, botSpaceID, target.ID, target.Name, target.ID, "Working on "+result.Name)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// loadTask fetches the task named by the taskId parameter, aborting unless
+// it belongs to botSpaceID.
+func (rh *RouteHandler) loadTask(c *gin.Context, botSpaceID, failure string) (types.SpaceTask, bool) {
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
+		return types.SpaceTask{}, false
+	}
+
+	task, err := rh.spaceTaskDB.GetByID(c, taskID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return task, false
+		}
+		rh.log.WithError(err).Error("failed to get task")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return task, false
+	}
+	if task.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return task, false
+	}
+	return task, true
+}
+
+// setTaskBotStatus sets the status of botID on its own behalf, for changes
+// made to its task by someone else.
+func (rh *RouteHandler) setTaskBotStatus(c *gin.Context, botSpaceID, botID, status string) {
+	bot, err := rh.botDB.GetByID(c, botID)
+	if err != nil {
+		rh.log.WithError(err).WithField("botID", botID).Debug("failed to get bot for status update")
+		return
+	}
+	rh.updateBotStatusForTask(c, botSpaceID, bot.ID, bot.Name, bot.ID, status)
+}
@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
+	ngerrors "github.com/numbergroup/errors"
+	"github.com/numbergroup/server"
+)
+
+// ApproveTaskReview signs off on a task in review, completing it.
+func (rh *RouteHandler) ApproveTaskReview(c *gin.Context) {
+	rh.reviewTask(c, true)
+}
+
+// RejectTaskReview sends a task in review back to its bot with feedback, or
+// makes it available if the bot has moved on to another task.
+func (rh *RouteHandler) RejectTaskReview(c *gin.Context) {
+	rh.reviewTask(c, false)
+}
+
+func (rh *RouteHandler) reviewTask(c *gin.Context, approve bool) {
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	if claims.IsBot && !claims.IsManager {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only manager bots and members can review tasks"})
+		return
+	}
+
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
+		return
+	}
+
+	var req types.ReviewTaskRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+	if !approve && req.Feedback == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "feedback is required to request changes"})
+		return
+	}
+
+	task, err := rh.spaceTaskDB.GetByID(c, taskID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to get task")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to review task"})
+		return
+	}
+
+	if task.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	if task.Status != types.TaskStatusInReview {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not in review"})
+		return
+	}
+	if claims.IsBot && task.BotID != nil && *task.BotID == claims.BotID {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "you cannot review your own task"})
+		return
+	}
+
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
+	now := time.Now()
+	fromStatus := task.Status
+	eventType := types.TaskEventReviewApproved
+	switch {
+	case approve:
+		task.Status = types.TaskStatusCompleted
+		task.CompletedAt = &now
+	case task.BotID != nil:
+		task.Status = types.TaskStatusInProgress
+		task.LeaseExpiresAt = rh.taskLease(now)
+		eventType = types.TaskEventChangesRequested
+	default:
+		// The bot which did the work is gone; let someone else pick it up.
+		task.Status = types.TaskStatusAvailable
+		eventType = types.TaskEventChangesRequested
+	}
+	task.UpdatedAt = now
+
+	taskEvent := newTaskEvent(claims, task, eventType, fromStatus)
+	taskEvent.Note = optionalText(req.Feedback)
+
+	var result types.SpaceTask
+	var unlocked []types.SpaceTask
+	if approve {
+		result, unlocked, err = rh.spaceTaskDB.Finish(c, task, taskEvent)
+	} else {
+		result, err = rh.spaceTaskDB.Update(c, task, taskEvent)
+	}
+	if ngerrors.Cause(err) == db.ErrBotBusy {
+		// The bot took other work while this task sat in review; let someone
+		// else pick up the changes.
+		task.Status = types.TaskStatusAvailable
+		task.BotID = nil
+		task.LeaseExpiresAt = nil
+		taskEvent = newTaskEvent(claims, task, eventType, fromStatus)
+		taskEvent.Note = optionalText(req.Feedback)
+		result, err = rh.spaceTaskDB.Update(c, task, taskEvent)
+	}
+	if err != nil {
+		rh.abortTaskWrite(c, err, "failed to review task")
+		return
+	}
+
+	if approve {
+		rh.hub.Publish(botSpaceID, ws.EventTaskReviewApproved, result)
+		rh.publishUnlocked(botSpaceID, unlocked)
+	} else {
+		rh.hub.Publish(botSpaceID, ws.EventTaskChangesRequested, result)
+		if result.BotID != nil {
+			bot, err := rh.botDB.GetByID(c, *result.BotID)
+			if err == nil {
+				rh.updateBotStatusForTask(c, botSpaceID, bot.ID, bot.Name, bot.ID, "Revising "+result.Name)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
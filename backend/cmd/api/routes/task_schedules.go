@@ -0,0 +1,316 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
+	ngerrors "github.com/numbergroup/errors"
+	"github.com/numbergroup/server"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleBatchSize caps how many due schedules a single scheduler pass runs.
+const scheduleBatchSize = 100
+
+// scheduleIntervalRuns is how many upcoming runs parseSchedule checks
+// against the minimum interval. Expressions like "0,1 * * * *" only fire
+// close together at some times of day, so one pair of runs is not enough.
+const scheduleIntervalRuns = 10
+
+// parseSchedule parses a cron expression evaluated in the named timezone,
+// which defaults to UTC. It rejects schedules whose upcoming runs come less
+// than minInterval apart; a zero minInterval skips the check.
+func parseSchedule(expr, timezone string, minInterval time.Duration) (cron.Schedule, *time.Location, error) {
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid timezone %q", timezone)
+	}
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cron expression: %v", err)
+	}
+	if minInterval > 0 {
+		prev := sched.Next(time.Now().In(loc))
+		for i := 0; i < scheduleIntervalRuns; i++ {
+			next := sched.Next(prev)
+			if next.Sub(prev) < minInterval {
+				return nil, nil, fmt.Errorf("schedule fires more often than every %s", minInterval)
+			}
+			prev = next
+		}
+	}
+	return sched, loc, nil
+}
+
+// nextRun returns the first time sched fires after now, in loc.
+func nextRun(sched cron.Schedule, loc *time.Location, now time.Time) time.Time {
+	return sched.Next(now.In(loc))
+}
+
+func (rh *RouteHandler) CreateTaskSchedule(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireManagerOrMember(c)
+	if !ok {
+		return
+	}
+
+	var req types.CreateTaskScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sched, loc, err := parseSchedule(req.Cron, req.Timezone, rh.conf.TaskScheduleMinInterval)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actorType, actorID := actorOf(claims)
+	now := time.Now()
+	schedule := types.TaskSchedule{
+		ID:            uuid.New().String(),
+		BotSpaceID:    botSpaceID,
+		Name:          req.Name,
+		Description:   req.Description,
+		Cron:          req.Cron,
+		Timezone:      loc.String(),
+		Priority:      req.Priority,
+		NextRunAt:     nextRun(sched, loc, now),
+		CreatedByType: actorType,
+		CreatedByID:   actorID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if req.RequiresReview != nil {
+		schedule.RequiresReview = *req.RequiresReview
+	} else {
+		space, err := rh.botSpaceDB.GetByID(c, botSpaceID)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to get bot space")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to create task schedule"})
+			return
+		}
+		schedule.RequiresReview = space.RequiresTaskReview
+	}
+
+	result, err := rh.scheduleDB.Insert(c, schedule)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to insert task schedule")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to create task schedule"})
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventScheduleCreated, result)
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rh *RouteHandler) ListTaskSchedules(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	schedules, err := rh.scheduleDB.ListByBotSpaceID(c, botSpaceID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list task schedules")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to list task schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+func (rh *RouteHandler) PauseTaskSchedule(c *gin.Context) {
+	rh.setTaskSchedulePaused(c, true)
+}
+
+// ResumeTaskSchedule restarts a paused schedule from its next run after now;
+// runs missed while it was paused are skipped.
+func (rh *RouteHandler) ResumeTaskSchedule(c *gin.Context) {
+	rh.setTaskSchedulePaused(c, false)
+}
+
+func (rh *RouteHandler) setTaskSchedulePaused(c *gin.Context, paused bool) {
+	_, botSpaceID, ok := rh.requireManagerOrMember(c)
+	if !ok {
+		return
+	}
+
+	schedule, ok := rh.loadTaskSchedule(c, botSpaceID)
+	if !ok {
+		return
+	}
+
+	nextRunAt := schedule.NextRunAt
+	if !paused {
+		sched, loc, err := parseSchedule(schedule.Cron, schedule.Timezone, 0)
+		if err != nil {
+			rh.log.WithError(err).WithField("scheduleID", schedule.ID).Error("failed to parse stored task schedule")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to resume task schedule"})
+			return
+		}
+		nextRunAt = nextRun(sched, loc, time.Now())
+	}
+
+	result, err := rh.scheduleDB.SetPaused(c, schedule.ID, paused, nextRunAt)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to update task schedule")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to update task schedule"})
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventScheduleUpdated, result)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DeleteTaskSchedule stops a schedule for good. Tasks it already created are
+// kept.
+func (rh *RouteHandler) DeleteTaskSchedule(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireManagerOrMember(c)
+	if !ok {
+		return
+	}
+
+	schedule, ok := rh.loadTaskSchedule(c, botSpaceID)
+	if !ok {
+		return
+	}
+
+	if err := rh.scheduleDB.Delete(c, schedule.ID); err != nil {
+		rh.log.WithError(err).Error("failed to delete task schedule")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to delete task schedule"})
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventScheduleDeleted, gin.H{"id": schedule.ID})
+
+	c.Status(http.StatusNoContent)
+}
+
+// loadTaskSchedule fetches the schedule named by the scheduleId parameter,
+// aborting unless it belongs to botSpaceID.
+func (rh *RouteHandler) loadTaskSchedule(c *gin.Context, botSpaceID string) (types.TaskSchedule, bool) {
+	scheduleID, err := server.GetUUIDParam(c, "scheduleId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid scheduleId"})
+		return types.TaskSchedule{}, false
+	}
+
+	schedule, err := rh.scheduleDB.GetByID(c, scheduleID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task schedule not found"})
+			return schedule, false
+		}
+		rh.log.WithError(err).Error("failed to get task schedule")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get task schedule"})
+		return schedule, false
+	}
+	if schedule.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task schedule not found"})
+		return schedule, false
+	}
+	return schedule, true
+}
+
+// RunTaskScheduler periodically creates tasks from due schedules until ctx
+// is done. Replicas may all run it; an advisory lock lets only one of them
+// materialize schedules at a time, and each run is claimed in the same
+// transaction that creates its task.
+func (rh *RouteHandler) RunTaskScheduler(ctx context.Context) {
+	ticker := time.NewTicker(rh.conf.TaskScheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := rh.scheduleDB.WithSchedulerLock(ctx, rh.runDueSchedules)
+			if err != nil {
+				rh.log.WithError(err).Error("failed to run task schedules")
+			}
+		}
+	}
+}
+
+// runDueSchedules runs the schedules due now. Any beyond the batch size are
+// left for the next tick.
+func (rh *RouteHandler) runDueSchedules(ctx context.Context) error {
+	now := time.Now()
+	due, err := rh.scheduleDB.ListDue(ctx, now, scheduleBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range due {
+		rh.runSchedule(ctx, schedule, now)
+	}
+	return nil
+}
+
+// runSchedule creates the task for a due run of schedule and moves it on to
+// its next run after now. Runs missed while the server was down collapse
+// into this one.
+func (rh *RouteHandler) runSchedule(ctx context.Context, schedule types.TaskSchedule, now time.Time) {
+	log := rh.log.WithField("scheduleID", schedule.ID)
+
+	sched, loc, err := parseSchedule(schedule.Cron, schedule.Timezone, 0)
+	if err != nil {
+		log.WithError(err).Error("failed to parse stored task schedule")
+		return
+	}
+
+	task := types.SpaceTask{
+		ID:             uuid.New().String(),
+		BotSpaceID:     schedule.BotSpaceID,
+		Name:           schedule.Name,
+		Description:    schedule.Description,
+		Status:         types.TaskStatusAvailable,
+		ScheduleID:     &schedule.ID,
+		DependsOn:      []string{},
+		Priority:       schedule.Priority,
+		RequiresReview: schedule.RequiresReview,
+		CreatedByType:  schedule.CreatedByType,
+		CreatedByID:    schedule.CreatedByID,
+		Version:        1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	taskEvent := types.TaskEvent{
+		ID:          uuid.New().String(),
+		TaskID:      task.ID,
+		BotSpaceID:  task.BotSpaceID,
+		Type:        types.TaskEventCreated,
+		ActorType:   types.ActorSystem,
+		ToStatus:    task.Status,
+		Note:        optionalText(fmt.Sprintf("created by schedule %q", schedule.Name)),
+		ArtifactIDs: []string{},
+		CreatedAt:   now,
+	}
+
+	created, err := rh.spaceTaskDB.InsertFromSchedule(ctx, task, taskEvent, schedule, nextRun(sched, loc, now))
+	if err != nil {
+		log.WithError(err).Error("failed to create scheduled task")
+		return
+	}
+	if !created {
+		return
+	}
+
+	rh.hub.Publish(task.BotSpaceID, ws.EventTaskCreated, task)
+}
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name        string
+		expr        string
+		timezone    string
+		minInterval time.Duration
+		wantLoc     string
+		wantErr     bool
+	}{
+		{name: "every minute", expr: "* * * * *", minInterval: time.Minute, wantLoc: "UTC"},
+		{name: "daily in a timezone", expr: "0 9 * * *", timezone: "Europe/Berlin", minInterval: time.Minute, wantLoc: "Europe/Berlin"},
+		{name: "descriptor", expr: "@hourly", minInterval: time.Hour, wantLoc: "UTC"},
+		{name: "every second", expr: "@every 1s", minInterval: time.Minute, wantErr: true},
+		{name: "every minute under a larger floor", expr: "* * * * *", minInterval: 5 * time.Minute, wantErr: true},
+		{name: "runs close together once an hour", expr: "0,1 * * * *", minInterval: 5 * time.Minute, wantErr: true},
+		{name: "no floor", expr: "@every 1s", wantLoc: "UTC"},
+		{name: "bad cron", expr: "* * *", minInterval: time.Minute, wantErr: true},
+		{name: "bad timezone", expr: "* * * * *", timezone: "Mars/Olympus", minInterval: time.Minute, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, loc, err := parseSchedule(tt.expr, tt.timezone, tt.minInterval)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSchedule(%q) succeeded, want an error", tt.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSchedule(%q) failed: %v", tt.expr, err)
+			}
+			if loc.String() != tt.wantLoc {
+				t.Errorf("got location %q, want %q", loc, tt.wantLoc)
+			}
+			now := time.Now()
+			if next := nextRun(sched, loc, now); !next.After(now) {
+				t.Errorf("got next run %v, want one after %v", next, now)
+			}
+		})
+	}
+}
@@ -1,19 +1,27 @@
 package routes
 
 import (
+	"context"
 	"database/sql"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/numbergroup/claw-swarm/pkg/db"
+	"github.com/numbergroup/claw-swarm/pkg/routing"
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
 	ngerrors "github.com/numbergroup/errors"
 	"github.com/numbergroup/server"
 )
 
 func (rh *RouteHandler) CreateTask(c *gin.Context) {
-	claims, botSpaceID, ok := rh.requireManagerBot(c)
+	claims, botSpaceID, ok := rh.requireManagerOrMember(c)
 	if !ok {
 		return
 	}
@@ -24,19 +32,84 @@ func (rh *RouteHandler) CreateTask(c *gin.Context) {
 		return
 	}
 
+	actorType, actorID := actorOf(claims)
 	now := time.Now()
 	task := types.SpaceTask{
-		ID:             uuid.New().String(),
-		BotSpaceID:     botSpaceID,
-		Name:           req.Name,
-		Description:    req.Description,
-		Status:         "available",
-		CreatedByBotID: claims.BotID,
-		CreatedAt:      now,
-		UpdatedAt:      now,
+		ID:            uuid.New().String(),
+		BotSpaceID:    botSpaceID,
+		Name:          req.Name,
+		Description:   req.Description,
+		Status:        types.TaskStatusAvailable,
+		CreatedByType: actorType,
+		CreatedByID:   actorID,
+		Priority:      req.Priority,
+		DueAt:         req.DueAt,
+		Version:       1,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if req.RequiresReview != nil {
+		task.RequiresReview = *req.RequiresReview
+	} else {
+		space, err := rh.botSpaceDB.GetByID(c, botSpaceID)
+		if err != nil {
+			rh.log.WithError(err).Error("failed to get bot space")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
+			return
+		}
+		task.RequiresReview = space.RequiresTaskReview
+	}
+
+	if skills := routing.NormalizeSkills(req.RequiredSkills); len(skills) > 0 {
+		task.RequiredSkills = pq.StringArray(skills)
+	}
+
+	if req.ParentTaskID != nil {
+		parent, err := rh.spaceTaskDB.GetByID(c, *req.ParentTaskID)
+		if err != nil && ngerrors.Cause(err) != sql.ErrNoRows {
+			rh.log.WithError(err).Error("failed to get parent task")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
+			return
+		}
+		if err != nil || parent.BotSpaceID != botSpaceID {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "parent task not found"})
+			return
+		}
+		task.ParentTaskID = &parent.ID
+	}
+
+	if len(req.DependsOn) > 0 {
+		ready, ok := rh.resolveTaskDependencies(c, botSpaceID, &task, req.DependsOn)
+		if !ok {
+			return
+		}
+		if !ready {
+			if req.BotID != nil {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "cannot assign a task before its dependencies are completed"})
+				return
+			}
+			task.Status = types.TaskStatusWaiting
+		}
+	}
+
+	// Without a bot, rank the idle ones for tasks which need skills or want
+	// to be routed, and take the best if asked to
+	var suggestions []types.TaskSuggestion
+	routed := false
+	if req.BotID == nil && task.Status == types.TaskStatusAvailable && (len(task.RequiredSkills) > 0 || req.AutoAssign) {
+		suggestions, ok = rh.suggestBots(c, botSpaceID, task.RequiredSkills, "failed to create task")
+		if !ok {
+			return
+		}
+		if req.AutoAssign && len(suggestions) > 0 {
+			req.BotID = &suggestions[0].BotID
+			routed = true
+		}
 	}
 
 	// If a bot is specified, assign it immediately
+	var assignee *types.Bot
 	if req.BotID != nil {
 		targetBotID := *req.BotID
 		bot, err := rh.botDB.GetByID(c, targetBotID)
@@ -69,19 +142,76 @@ func (rh *RouteHandler) CreateTask(c *gin.Context) {
 		}
 
 		task.BotID = &targetBotID
-		task.Status = "in_progress"
+		task.Status = types.TaskStatusInProgress
+		task.LeaseExpiresAt = rh.taskLease(now)
+		assignee = &bot
+	}
 
-		rh.updateBotStatusForTask(c, botSpaceID, targetBotID, bot.Name, claims.BotID, "Working on "+task.Name)
+	taskEvent := newTaskEvent(claims, task, types.TaskEventCreated, "")
+	if routed {
+		taskEvent.Note = optionalText("assigned to " + assignee.Name + " by skill match")
+	} else if assignee != nil {
+		taskEvent.Note = optionalText("assigned to " + assignee.Name)
 	}
 
-	result, err := rh.spaceTaskDB.Insert(c, task)
+	result, err := rh.spaceTaskDB.Insert(c, task, taskEvent)
 	if err != nil {
-		rh.log.WithError(err).Error("failed to insert task")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
+		rh.abortTaskWrite(c, err, "failed to create task")
 		return
 	}
 
-	c.JSON(http.StatusCreated, result)
+	if assignee != nil {
+		rh.updateBotStatusForTask(c, botSpaceID, assignee.ID, assignee.Name, statusAuthor(claims, assignee.ID), "Working on "+task.Name)
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventTaskCreated, result)
+
+	c.JSON(http.StatusCreated, types.CreateTaskResponse{SpaceTask: result, Suggestions: suggestions})
+}
+
+// suggestBots ranks the free bots of the space for a task requiring skills,
+// which may be none.
+func (rh *RouteHandler) suggestBots(c *gin.Context, botSpaceID string, skills []string, failure string) ([]types.TaskSuggestion, bool) {
+	bots, err := rh.botDB.ListByBotSpaceID(c, botSpaceID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list bots")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return nil, false
+	}
+
+	botSkills, err := rh.botSkillDB.ListByBotSpaceID(c, botSpaceID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list bot skills")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return nil, false
+	}
+
+	loads, err := rh.spaceTaskDB.ListBotLoads(c, botSpaceID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list bot task loads")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return nil, false
+	}
+
+	candidates := make([]routing.Candidate, len(bots))
+	byBotID := make(map[string]*routing.Candidate, len(bots))
+	for i, bot := range bots {
+		bot.Presence = rh.presence.State(bot)
+		candidates[i] = routing.Candidate{Bot: bot, Load: types.BotTaskLoad{BotID: bot.ID}}
+		byBotID[bot.ID] = &candidates[i]
+	}
+	for _, skill := range botSkills {
+		if candidate, ok := byBotID[skill.BotID]; ok {
+			candidate.Skills = append(candidate.Skills, skill)
+		}
+	}
+	for _, load := range loads {
+		if candidate, ok := byBotID[load.BotID]; ok {
+			candidate.Load = load
+		}
+	}
+
+	return routing.Rank(skills, candidates), true
 }
 
 func (rh *RouteHandler) ListTasks(c *gin.Context) {
@@ -90,22 +220,31 @@ func (rh *RouteHandler) ListTasks(c *gin.Context) {
 		return
 	}
 
-	if !claims.IsBot {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only bots can list tasks"})
-		return
+	var filter types.TaskFilter
+	if s := c.Query("status"); s != "" {
+		filter.Status = &s
 	}
-
-	var status *string
-	if claims.IsManager {
-		if s := c.Query("status"); s != "" {
-			status = &s
+	if b := c.Query("botId"); b != "" {
+		if _, err := uuid.Parse(b); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid botId"})
+			return
 		}
-	} else {
-		available := "available"
-		status = &available
+		filter.BotID = &b
+	}
+	if p := c.Query("parentTaskId"); p != "" {
+		if _, err := uuid.Parse(p); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid parentTaskId"})
+			return
+		}
+		filter.ParentTaskID = &p
+	}
+	// Workers only see the tasks they could pick up.
+	if claims.IsBot && !claims.IsManager {
+		available := types.TaskStatusAvailable
+		filter.Status = &available
 	}
 
-	tasks, err := rh.spaceTaskDB.ListByBotSpaceID(c, botSpaceID, status)
+	tasks, err := rh.spaceTaskDB.ListByBotSpaceID(c, botSpaceID, filter)
 	if err != nil {
 		rh.log.WithError(err).Error("failed to list tasks")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to list tasks"})
@@ -140,6 +279,93 @@ func (rh *RouteHandler) GetCurrentTask(c *gin.Context) {
 	c.JSON(http.StatusOK, task)
 }
 
+// GetTask returns a task with its event log and the artifacts linked to it.
+func (rh *RouteHandler) GetTask(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
+		return
+	}
+
+	task, err := rh.spaceTaskDB.GetByID(c, taskID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to get task")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get task"})
+		return
+	}
+	if task.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+
+	events, err := rh.spaceTaskDB.ListEvents(c, task.ID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list task events")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get task"})
+		return
+	}
+
+	var artifactIDs []string
+	for _, event := range events {
+		artifactIDs = append(artifactIDs, event.ArtifactIDs...)
+	}
+	artifacts, err := rh.artifactDB.ListByIDs(c, botSpaceID, artifactIDs)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list task artifacts")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get task"})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.TaskDetail{SpaceTask: task, Events: events, Artifacts: artifacts})
+}
+
+// GetTaskHistory returns the audit log of a task, oldest event first.
+func (rh *RouteHandler) GetTaskHistory(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
+		return
+	}
+
+	task, err := rh.spaceTaskDB.GetByID(c, taskID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to get task")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get task history"})
+		return
+	}
+	if task.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+
+	events, err := rh.spaceTaskDB.ListEvents(c, task.ID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list task events")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get task history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.TaskHistoryResponse{Events: events, Count: len(events)})
+}
+
 func (rh *RouteHandler) AcceptTask(c *gin.Context) {
 	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
 	if !ok {
@@ -187,24 +413,35 @@ func (rh *RouteHandler) AcceptTask(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
 		return
 	}
-	if task.Status != "available" {
+	if task.Status == types.TaskStatusWaiting {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is waiting on its dependencies", "dependsOn": task.DependsOn})
+		return
+	}
+	if task.Status != types.TaskStatusAvailable {
 		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not available"})
 		return
 	}
 
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
 	botID := claims.BotID
 	now := time.Now()
-	task.Status = "in_progress"
+	fromStatus := task.Status
+	task.Status = types.TaskStatusInProgress
 	task.BotID = &botID
+	task.LeaseExpiresAt = rh.taskLease(now)
 	task.UpdatedAt = now
 
-	result, err := rh.spaceTaskDB.Update(c, task)
+	result, err := rh.spaceTaskDB.Update(c, task, newTaskEvent(claims, task, types.TaskEventAccepted, fromStatus))
 	if err != nil {
-		rh.log.WithError(err).Error("failed to update task")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to accept task"})
+		rh.abortTaskWrite(c, err, "failed to accept task")
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventTaskAccepted, result)
+
 	bot, err := rh.botDB.GetByID(c, claims.BotID)
 	if err == nil {
 		rh.updateBotStatusForTask(c, botSpaceID, claims.BotID, bot.Name, claims.BotID, "Working on "+task.Name)
@@ -213,6 +450,53 @@ func (rh *RouteHandler) AcceptTask(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// ClaimNextTask assigns the calling bot the highest priority available task,
+// soonest due first.
+func (rh *RouteHandler) ClaimNextTask(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	if !claims.IsBot {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only bots can claim tasks"})
+		return
+	}
+
+	activeTask, err := rh.spaceTaskDB.GetActiveByBotID(c, botSpaceID, claims.BotID)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to check active task")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to claim task"})
+		return
+	}
+	if activeTask != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error":       "you already have an active task",
+			"currentTask": activeTask,
+		})
+		return
+	}
+
+	task, err := rh.spaceTaskDB.ClaimNext(c, botSpaceID, claims.BotID, *rh.taskLease(time.Now()))
+	if err != nil {
+		rh.abortTaskWrite(c, err, "failed to claim task")
+		return
+	}
+	if task == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no available tasks"})
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventTaskAccepted, task)
+
+	bot, err := rh.botDB.GetByID(c, claims.BotID)
+	if err == nil {
+		rh.updateBotStatusForTask(c, botSpaceID, claims.BotID, bot.Name, claims.BotID, "Working on "+task.Name)
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
 func (rh *RouteHandler) CompleteTask(c *gin.Context) {
 	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
 	if !ok {
@@ -230,6 +514,11 @@ func (rh *RouteHandler) CompleteTask(c *gin.Context) {
 		return
 	}
 
+	var req types.CompleteTaskRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
 	task, err := rh.spaceTaskDB.GetByID(c, taskID.String())
 	if err != nil {
 		if ngerrors.Cause(err) == sql.ErrNoRows {
@@ -245,7 +534,7 @@ func (rh *RouteHandler) CompleteTask(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
 		return
 	}
-	if task.Status != "in_progress" {
+	if task.Status != types.TaskStatusInProgress {
 		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not in progress"})
 		return
 	}
@@ -254,18 +543,51 @@ func (rh *RouteHandler) CompleteTask(c *gin.Context) {
 		return
 	}
 
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
+	artifactIDs, ok := rh.resolveTaskArtifacts(c, botSpaceID, req.ArtifactIDs)
+	if !ok {
+		return
+	}
+
 	now := time.Now()
-	task.Status = "completed"
-	task.CompletedAt = &now
+	fromStatus := task.Status
+	eventType := types.TaskEventCompleted
+	if task.RequiresReview {
+		task.Status = types.TaskStatusInReview
+		eventType = types.TaskEventSubmitted
+	} else {
+		task.Status = types.TaskStatusCompleted
+		task.CompletedAt = &now
+	}
+	task.LeaseExpiresAt = nil
 	task.UpdatedAt = now
 
-	result, err := rh.spaceTaskDB.Update(c, task)
+	taskEvent := newTaskEvent(claims, task, eventType, fromStatus)
+	taskEvent.Result = optionalText(req.Result)
+	taskEvent.ArtifactIDs = artifactIDs
+
+	var result types.SpaceTask
+	var unlocked []types.SpaceTask
+	if task.Status == types.TaskStatusCompleted {
+		result, unlocked, err = rh.spaceTaskDB.Finish(c, task, taskEvent)
+	} else {
+		result, err = rh.spaceTaskDB.Update(c, task, taskEvent)
+	}
 	if err != nil {
-		rh.log.WithError(err).Error("failed to update task")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to complete task"})
+		rh.abortTaskWrite(c, err, "failed to complete task")
 		return
 	}
 
+	if result.Status == types.TaskStatusInReview {
+		rh.hub.Publish(botSpaceID, ws.EventTaskSubmitted, result)
+	} else {
+		rh.hub.Publish(botSpaceID, ws.EventTaskCompleted, result)
+		rh.publishUnlocked(botSpaceID, unlocked)
+	}
+
 	bot, err := rh.botDB.GetByID(c, claims.BotID)
 	if err == nil {
 		rh.updateBotStatusForTask(c, botSpaceID, claims.BotID, bot.Name, claims.BotID, "")
@@ -291,6 +613,11 @@ func (rh *RouteHandler) BlockTask(c *gin.Context) {
 		return
 	}
 
+	var req types.BlockTaskRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
 	task, err := rh.spaceTaskDB.GetByID(c, taskID.String())
 	if err != nil {
 		if ngerrors.Cause(err) == sql.ErrNoRows {
@@ -306,7 +633,7 @@ func (rh *RouteHandler) BlockTask(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
 		return
 	}
-	if task.Status != "in_progress" {
+	if task.Status != types.TaskStatusInProgress {
 		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not in progress"})
 		return
 	}
@@ -315,17 +642,33 @@ func (rh *RouteHandler) BlockTask(c *gin.Context) {
 		return
 	}
 
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
+	artifactIDs, ok := rh.resolveTaskArtifacts(c, botSpaceID, req.ArtifactIDs)
+	if !ok {
+		return
+	}
+
 	now := time.Now()
-	task.Status = "blocked"
+	fromStatus := task.Status
+	task.Status = types.TaskStatusBlocked
+	task.LeaseExpiresAt = nil
 	task.UpdatedAt = now
 
-	result, err := rh.spaceTaskDB.Update(c, task)
+	taskEvent := newTaskEvent(claims, task, types.TaskEventBlocked, fromStatus)
+	taskEvent.Reason = optionalText(req.Reason)
+	taskEvent.ArtifactIDs = artifactIDs
+
+	result, err := rh.spaceTaskDB.Update(c, task, taskEvent)
 	if err != nil {
-		rh.log.WithError(err).Error("failed to update task")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to block task"})
+		rh.abortTaskWrite(c, err, "failed to block task")
 		return
 	}
 
+	rh.hub.Publish(botSpaceID, ws.EventTaskBlocked, result)
+
 	bot, err := rh.botDB.GetByID(c, claims.BotID)
 	if err == nil {
 		rh.updateBotStatusForTask(c, botSpaceID, claims.BotID, bot.Name, claims.BotID, "")
@@ -335,7 +678,7 @@ func (rh *RouteHandler) BlockTask(c *gin.Context) {
 }
 
 func (rh *RouteHandler) AssignTask(c *gin.Context) {
-	claims, botSpaceID, ok := rh.requireManagerBot(c)
+	claims, botSpaceID, ok := rh.requireManagerOrMember(c)
 	if !ok {
 		return
 	}
@@ -396,29 +739,234 @@ func (rh *RouteHandler) AssignTask(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
 		return
 	}
-	if task.Status != "available" {
+	if task.Status == types.TaskStatusWaiting {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is waiting on its dependencies", "dependsOn": task.DependsOn})
+		return
+	}
+	if task.Status != types.TaskStatusAvailable {
 		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not available"})
 		return
 	}
 
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
 	now := time.Now()
-	task.Status = "in_progress"
+	fromStatus := task.Status
+	task.Status = types.TaskStatusInProgress
 	task.BotID = &req.BotID
+	task.LeaseExpiresAt = rh.taskLease(now)
 	task.UpdatedAt = now
 
-	result, err := rh.spaceTaskDB.Update(c, task)
+	taskEvent := newTaskEvent(claims, task, types.TaskEventAssigned, fromStatus)
+	taskEvent.Note = optionalText("assigned to " + bot.Name)
+
+	result, err := rh.spaceTaskDB.Update(c, task, taskEvent)
 	if err != nil {
-		rh.log.WithError(err).Error("failed to update task")
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to assign task"})
+		rh.abortTaskWrite(c, err, "failed to assign task")
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, ws.EventTaskAssigned, result)
+
+	rh.updateBotStatusForTask(c, botSpaceID, req.BotID, bot.Name, statusAuthor(claims, req.BotID), "Working on "+task.Name)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ProposeSubtask lets the bot working on a task break it down further. A
+// manager's subtasks are created available; anyone else's wait in proposed
+// until a manager approves them.
+func (rh *RouteHandler) ProposeSubtask(c *gin.Context) {
+	claims, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	if !claims.IsBot {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only bots can propose subtasks"})
+		return
+	}
+
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
+		return
+	}
+
+	var req types.ProposeSubtaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parent, err := rh.spaceTaskDB.GetByID(c, taskID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to get task")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to propose subtask"})
+		return
+	}
+
+	if parent.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	if !claims.IsManager && (parent.Status != types.TaskStatusInProgress || parent.BotID == nil || *parent.BotID != claims.BotID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "subtasks can only be proposed under your current task"})
+		return
+	}
+
+	status := types.TaskStatusProposed
+	event := ws.EventTaskProposed
+	if claims.IsManager {
+		status = types.TaskStatusAvailable
+		event = ws.EventTaskCreated
+	}
+
+	now := time.Now()
+	task := types.SpaceTask{
+		ID:             uuid.New().String(),
+		BotSpaceID:     botSpaceID,
+		Name:           req.Name,
+		Description:    req.Description,
+		Status:         status,
+		ParentTaskID:   &parent.ID,
+		RequiresReview: parent.RequiresReview,
+		CreatedByType:  types.ActorBot,
+		CreatedByID:    claims.BotID,
+		Version:        1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	eventType := types.TaskEventProposed
+	if claims.IsManager {
+		eventType = types.TaskEventCreated
+	}
+
+	result, err := rh.spaceTaskDB.Insert(c, task, newTaskEvent(claims, task, eventType, ""))
+	if err != nil {
+		rh.abortTaskWrite(c, err, "failed to propose subtask")
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, event, result)
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func (rh *RouteHandler) ApproveSubtask(c *gin.Context) {
+	rh.reviewSubtask(c, types.TaskStatusAvailable, ws.EventTaskApproved, types.TaskEventApproved, "failed to approve subtask")
+}
+
+func (rh *RouteHandler) RejectSubtask(c *gin.Context) {
+	rh.reviewSubtask(c, types.TaskStatusRejected, ws.EventTaskRejected, types.TaskEventRejected, "failed to reject subtask")
+}
+
+func (rh *RouteHandler) reviewSubtask(c *gin.Context, status, event, eventType, failure string) {
+	claims, botSpaceID, ok := rh.requireManagerBot(c)
+	if !ok {
+		return
+	}
+
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
 		return
 	}
 
-	rh.updateBotStatusForTask(c, botSpaceID, req.BotID, bot.Name, claims.BotID, "Working on "+task.Name)
+	task, err := rh.spaceTaskDB.GetByID(c, taskID.String())
+	if err != nil {
+		if ngerrors.Cause(err) == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return
+		}
+		rh.log.WithError(err).Error("failed to get task")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return
+	}
+
+	if task.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	if task.Status != types.TaskStatusProposed {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task is not a pending proposal"})
+		return
+	}
+	if !rh.checkTaskVersion(c, task) {
+		return
+	}
+
+	fromStatus := task.Status
+	task.Status = status
+	task.UpdatedAt = time.Now()
+
+	result, err := rh.spaceTaskDB.Update(c, task, newTaskEvent(claims, task, eventType, fromStatus))
+	if err != nil {
+		rh.abortTaskWrite(c, err, failure)
+		return
+	}
+
+	rh.hub.Publish(botSpaceID, event, result)
 
 	c.JSON(http.StatusOK, result)
 }
 
-func (rh *RouteHandler) updateBotStatusForTask(c *gin.Context, botSpaceID, botID, botName, updatedByBotID, status string) {
+// GetTaskTree returns a task with all of its subtasks nested beneath it.
+func (rh *RouteHandler) GetTaskTree(c *gin.Context) {
+	_, botSpaceID, ok := rh.requireSpaceAccess(c)
+	if !ok {
+		return
+	}
+
+	taskID, err := server.GetUUIDParam(c, "taskId")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid taskId"})
+		return
+	}
+
+	tasks, err := rh.spaceTaskDB.ListSubtree(c, taskID.String())
+	if err != nil {
+		rh.log.WithError(err).Error("failed to list task subtree")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get task tree"})
+		return
+	}
+
+	nodes := make(map[string]*types.TaskTree, len(tasks))
+	for _, task := range tasks {
+		nodes[task.ID] = &types.TaskTree{SpaceTask: task, Children: []*types.TaskTree{}}
+	}
+	root, found := nodes[taskID.String()]
+	if !found || root.BotSpaceID != botSpaceID {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	for _, task := range tasks {
+		if task.ID == root.ID || task.ParentTaskID == nil {
+			continue
+		}
+		if parent, ok := nodes[*task.ParentTaskID]; ok {
+			parent.Children = append(parent.Children, nodes[task.ID])
+		}
+	}
+
+	c.JSON(http.StatusOK, root)
+}
+
+// publishUnlocked announces the tasks a finished task made available.
+func (rh *RouteHandler) publishUnlocked(botSpaceID string, unlocked []types.SpaceTask) {
+	for _, dependent := range unlocked {
+		rh.hub.Publish(botSpaceID, ws.EventTaskUnlocked, dependent)
+	}
+}
+
+func (rh *RouteHandler) updateBotStatusForTask(ctx context.Context, botSpaceID, botID, botName, updatedByBotID, status string) {
 	now := time.Now()
 	botStatus := types.BotStatus{
 		ID:             uuid.New().String(),
@@ -430,7 +978,162 @@ func (rh *RouteHandler) updateBotStatusForTask(c *gin.Context, botSpaceID, botID
 		CreatedAt:      now,
 		UpdatedAt:      now,
 	}
-	if _, err := rh.botStatusDB.Upsert(c, botStatus); err != nil {
+	result, err := rh.botStatusDB.Upsert(ctx, botStatus)
+	if err != nil {
 		rh.log.WithError(err).Error("failed to update bot status for task")
+		return
+	}
+	rh.hub.Publish(botSpaceID, ws.EventStatusUpdated, result)
+}
+
+// checkTaskVersion rejects the request if it carries an If-Match version
+// which no longer matches task.
+func (rh *RouteHandler) checkTaskVersion(c *gin.Context, task types.SpaceTask) bool {
+	ifMatch := strings.Trim(c.GetHeader("If-Match"), `"`)
+	if ifMatch == "" {
+		return true
+	}
+	version, err := strconv.Atoi(ifMatch)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match version"})
+		return false
+	}
+	if version != task.Version {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task version mismatch", "task": task})
+		return false
+	}
+	return true
+}
+
+// newTaskEvent starts the audit record of claims moving task from
+// fromStatus, which is empty for new tasks, to its current status.
+func newTaskEvent(claims *types.Claims, task types.SpaceTask, eventType, fromStatus string) types.TaskEvent {
+	actorType, actorID := actorOf(claims)
+	event := types.TaskEvent{
+		ID:          uuid.New().String(),
+		TaskID:      task.ID,
+		BotSpaceID:  task.BotSpaceID,
+		Type:        eventType,
+		ActorType:   actorType,
+		ActorID:     &actorID,
+		ToStatus:    task.Status,
+		ArtifactIDs: []string{},
+		CreatedAt:   task.UpdatedAt,
+	}
+	if fromStatus != "" {
+		event.FromStatus = &fromStatus
+	}
+	return event
+}
+
+// actorOf returns the actor type and id of the bot or user behind claims.
+func actorOf(claims *types.Claims) (string, string) {
+	if claims.IsBot {
+		return types.ActorBot, claims.BotID
+	}
+	return types.ActorUser, claims.UserID
+}
+
+// statusAuthor returns the bot to record as having updated botID's status on
+// behalf of claims. Users are not bots, so their changes are recorded as
+// made by the bot itself.
+func statusAuthor(claims *types.Claims, botID string) string {
+	if claims.IsBot {
+		return claims.BotID
+	}
+	return botID
+}
+
+// resolveTaskArtifacts checks that every artifact a bot reports with a task
+// belongs to the space, and returns the ids without duplicates.
+func (rh *RouteHandler) resolveTaskArtifacts(c *gin.Context, botSpaceID string, ids []string) ([]string, bool) {
+	unique := make([]string, 0, len(ids))
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+
+	artifacts, err := rh.artifactDB.ListByIDs(c, botSpaceID, unique)
+	if err != nil {
+		rh.log.WithError(err).Error("failed to look up task artifacts")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to look up artifacts"})
+		return nil, false
+	}
+	if len(artifacts) != len(unique) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "artifact not found"})
+		return nil, false
+	}
+	return unique, true
+}
+
+// bindOptionalJSON binds the request body into obj when there is one, so
+// endpoints which grew a body keep accepting requests without it.
+func bindOptionalJSON(c *gin.Context, obj any) bool {
+	if err := c.ShouldBindJSON(obj); err != nil && !ngerrors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+func optionalText(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// abortTaskWrite maps a failed conditional task write to a response.
+func (rh *RouteHandler) abortTaskWrite(c *gin.Context, err error, message string) {
+	switch ngerrors.Cause(err) {
+	case db.ErrTaskConflict:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "task was modified concurrently; reload and retry"})
+	case db.ErrBotBusy:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "bot already has an active task"})
+	case db.ErrTaskNotReady:
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "cannot assign a task before its dependencies are completed"})
+	default:
+		rh.log.WithError(err).Error(message)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// resolveTaskDependencies validates dependsOn for a new task and records it
+// on task. ready is false while any dependency is neither completed nor
+// cancelled; it only guides routing, as Insert settles the initial status
+// under lock. Dependencies are only set on create, when nothing can depend
+// on the new task yet, so they cannot form a cycle.
+func (rh *RouteHandler) resolveTaskDependencies(c *gin.Context, botSpaceID string, task *types.SpaceTask, dependsOn []string) (ready bool, ok bool) {
+	seen := make(map[string]struct{}, len(dependsOn))
+	ready = true
+	for _, depID := range dependsOn {
+		if _, dup := seen[depID]; dup {
+			continue
+		}
+		seen[depID] = struct{}{}
+
+		dep, err := rh.spaceTaskDB.GetByID(c, depID)
+		if err != nil {
+			if ngerrors.Cause(err) == sql.ErrNoRows {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dependency not found", "taskId": depID})
+				return false, false
+			}
+			rh.log.WithError(err).Error("failed to get dependency task")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
+			return false, false
+		}
+		if dep.BotSpaceID != botSpaceID {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dependency not found", "taskId": depID})
+			return false, false
+		}
+		if dep.Status != types.TaskStatusCompleted && dep.Status != types.TaskStatusCancelled {
+			ready = false
+		}
+		task.DependsOn = append(task.DependsOn, depID)
 	}
+	return ready, true
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"time"
 
 	"github.com/numbergroup/claw-swarm/pkg/config"
 	"github.com/numbergroup/claw-swarm/pkg/db"
@@ -28,6 +29,18 @@ func main() {
 		log.WithError(err).Fatal("failed to create message db")
 	}
 
+	botTokenDB, err := db.NewBotTokenDB(ctx, conf, sdb)
+	if err != nil {
+		log.WithError(err).Fatal("failed to create bot token db")
+	}
+
+	expired, err := botTokenDB.DeleteExpiredBefore(ctx, time.Now())
+	if err != nil {
+		log.WithError(err).Error("failed to delete expired bot tokens")
+	} else {
+		log.WithField("deleted", expired).Info("cleaned up expired bot tokens")
+	}
+
 	spaceIDs, err := messageDB.ListSpaceIDsExceedingCount(ctx, conf.MaxMessagesPerSpace)
 	if err != nil {
 		log.WithError(err).Fatal("failed to list spaces exceeding message limit")
@@ -2,6 +2,9 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
@@ -19,13 +22,38 @@ type Config struct {
 	PSQL         psql.Config
 	Secrets      []string `env:"SECRETS" env-default:""`
 	// #nosec G117
-	JWTSecret           string        `env:"JWT_SECRET"`
-	JWTExpiration       time.Duration `env:"JWT_EXPIRATION" env-default:"24h"`
-	MaxBodySize         int64         `env:"MAX_BODY_SIZE" env-default:"1048576"`
-	MaxMessagesPerPage  int           `env:"MAX_MESSAGES_PER_PAGE" env-default:"30"`
-	MaxMessageLength    int           `env:"MAX_MESSAGE_LENGTH" env-default:"10000"`
-	DisableSignup       bool          `env:"DISABLE_SIGNUP" env-default:"false"`
-	MaxMessagesPerSpace int           `env:"MAX_MESSAGES_PER_SPACE" env-default:"500"`
+	JWTSecret string `env:"JWT_SECRET"`
+	// JWTKeys is a keyring.Spec JSON document for signing key rotation.
+	// #nosec G117
+	JWTKeys                 string        `env:"JWT_KEYS" env-default:""`
+	JWTExpiration           time.Duration `env:"JWT_EXPIRATION" env-default:"24h"`
+	BotJWTExpiration        time.Duration `env:"BOT_JWT_EXPIRATION" env-default:"720h"`
+	BotAuthCacheTTL         time.Duration `env:"BOT_AUTH_CACHE_TTL" env-default:"5s"`
+	MaxBodySize             int64         `env:"MAX_BODY_SIZE" env-default:"1048576"`
+	MaxMessagesPerPage      int           `env:"MAX_MESSAGES_PER_PAGE" env-default:"30"`
+	MaxMessageLength        int           `env:"MAX_MESSAGE_LENGTH" env-default:"10000"`
+	DisableSignup           bool          `env:"DISABLE_SIGNUP" env-default:"false"`
+	MaxMessagesPerSpace     int           `env:"MAX_MESSAGES_PER_SPACE" env-default:"500"`
+	BroadcastBackend        string        `env:"BROADCAST_BACKEND" env-default:"memory"`
+	LongPollTimeout         time.Duration `env:"LONG_POLL_TIMEOUT" env-default:"30s"`
+	TaskLeaseDuration       time.Duration `env:"TASK_LEASE_DURATION" env-default:"10m"`
+	TaskReapInterval        time.Duration `env:"TASK_REAP_INTERVAL" env-default:"1m"`
+	TaskScheduleInterval    time.Duration `env:"TASK_SCHEDULE_INTERVAL" env-default:"30s"`
+	TaskScheduleMinInterval time.Duration `env:"TASK_SCHEDULE_MIN_INTERVAL" env-default:"1m"`
+	MessageEmbedInterval    time.Duration `env:"MESSAGE_EMBED_INTERVAL" env-default:"30s"`
+	MessageEmbedAttempts    int           `env:"MESSAGE_EMBED_ATTEMPTS" env-default:"5"`
+	MessageEmbedRetryDelay  time.Duration `env:"MESSAGE_EMBED_RETRY_DELAY" env-default:"5m"`
+	SlowConsumerPolicy      string        `env:"SLOW_CONSUMER_POLICY" env-default:"drop"`
+	MetricsListen           string        `env:"METRICS_LISTEN" env-default:":9090"`
+	PresenceIdleAfter       time.Duration `env:"PRESENCE_IDLE_AFTER" env-default:"2m"`
+	PresenceOfflineAfter    time.Duration `env:"PRESENCE_OFFLINE_AFTER" env-default:"10m"`
+	EmbedderBackend         string        `env:"EMBEDDER_BACKEND" env-default:"hash"`
+	EmbedderURL             string        `env:"EMBEDDER_URL" env-default:""`
+	EmbedderModel           string        `env:"EMBEDDER_MODEL" env-default:"text-embedding-3-small"`
+	EmbedderTimeout         time.Duration `env:"EMBEDDER_TIMEOUT" env-default:"10s"`
+	EmbedderMaxInputChars   int           `env:"EMBEDDER_MAX_INPUT_CHARS" env-default:"8000"`
+	// #nosec G117
+	EmbedderAPIKey string `env:"EMBEDDER_API_KEY" env-default:""`
 }
 
 func (c Config) ConnectPSQL(ctx context.Context) (*sqlx.DB, error) {
@@ -40,6 +68,40 @@ func (c Config) ConnectPSQL(ctx context.Context) (*sqlx.DB, error) {
 	return dbConn, nil
 }
 
+// PSQLConnString builds a lib/pq connection string from the PSQL config, for
+// callers such as pq.Listener that need their own dedicated connection.
+func (c Config) PSQLConnString() (string, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
+		connValue(c.PSQL.DBHost), c.PSQL.DBPort, connValue(c.PSQL.DBUser),
+		connValue(c.PSQL.DBPassword), connValue(c.PSQL.DBName))
+	if !c.PSQL.IsSSLEnabled() {
+		return connStr + " sslmode=disable", nil
+	}
+
+	sslKeyData, err := os.ReadFile(c.PSQL.SSLKeyFile)
+	if err != nil {
+		return "", err
+	}
+	sslCertData, err := os.ReadFile(c.PSQL.SSLCertFile)
+	if err != nil {
+		return "", err
+	}
+	sslRootCertData, err := os.ReadFile(c.PSQL.SSLRootCertFile)
+	if err != nil {
+		return "", err
+	}
+	return connStr + fmt.Sprintf(" sslmode=verify-ca sslinline=true sslkey=%s sslcert=%s sslrootcert=%s",
+		connValue(string(sslKeyData)), connValue(string(sslCertData)), connValue(string(sslRootCertData))), nil
+}
+
+// connValue quotes a connection string value, escaping the backslashes and
+// quotes inside it, so values with spaces, quotes or '=' survive parsing.
+func connValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
 func NewConfig(ctx context.Context) (*Config, error) {
 	conf := &Config{}
 	err := cleanenv.ReadEnv(conf)
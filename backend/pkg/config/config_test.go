@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/innodv/psql"
+	"github.com/lib/pq"
+)
+
+func TestPSQLConnString(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{name: "plain", password: "secret"},
+		{name: "spaces", password: "correct horse battery staple"},
+		{name: "quotes", password: `it's "quoted"`},
+		{name: "equals", password: "a=b c=d"},
+		{name: "backslashes", password: `back\slash\'`},
+		{name: "empty", password: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Config{PSQL: psql.Config{
+				DBHost:     "db.internal",
+				DBPort:     5432,
+				DBUser:     "claw user",
+				DBPassword: tt.password,
+				DBName:     "claw",
+			}}
+			connStr, err := conf.PSQLConnString()
+			if err != nil {
+				t.Fatalf("failed to build connection string: %v", err)
+			}
+			parsed, err := pq.NewConfig(connStr)
+			if err != nil {
+				t.Fatalf("failed to parse %q: %v", connStr, err)
+			}
+			if parsed.Password != tt.password || parsed.User != "claw user" || parsed.Database != "claw" || parsed.Host != "db.internal" {
+				t.Errorf("parsed %q as user %q password %q dbname %q host %q",
+					connStr, parsed.User, parsed.Password, parsed.Database, parsed.Host)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/innodv/psql"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/numbergroup/claw-swarm/pkg/config"
 	"github.com/numbergroup/claw-swarm/pkg/types"
 	"github.com/numbergroup/errors"
@@ -22,6 +23,8 @@ type artifactDB struct {
 	listBeforeCursor *sqlx.Stmt
 	getCreatedAt     *sqlx.Stmt
 	deleteStmt       *sqlx.Stmt
+	listByIDs        *sqlx.Stmt
+	search           *sqlx.Stmt
 }
 
 func NewArtifactDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (ArtifactDB, error) {
@@ -59,6 +62,25 @@ func NewArtifactDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Arti
 		return nil, errors.Wrap(err, "failed to prepare delete statement")
 	}
 
+	listByIDs, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM artifacts WHERE bot_space_id = $1 AND id = ANY($2) ORDER BY created_at ASC`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listByIDs statement")
+	}
+
+	// Snippets are taken from the same prefixes of the description and data
+	// that migration 021 indexes, keeping ts_headline's work bounded.
+	search, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s, rank, ts_headline('english', left(description, 10000) || E'\n' || left(data, 100000), query, '%s') AS snippet FROM (
+			SELECT a.*, ts_rank(a.search_vector, query) AS rank, query
+			FROM artifacts a, websearch_to_tsquery('english', $2) query
+			WHERE a.bot_space_id = $1 AND a.search_vector @@ query
+			ORDER BY rank DESC, a.created_at DESC LIMIT $3 OFFSET $4
+		) hits ORDER BY rank DESC, created_at DESC`, colStr, searchHeadlineOptions))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare search statement")
+	}
+
 	return &artifactDB{
 		db:               sdb,
 		log:              conf.GetLogger(),
@@ -68,6 +90,8 @@ func NewArtifactDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Arti
 		listBeforeCursor: listBeforeCursor,
 		getCreatedAt:     getCreatedAt,
 		deleteStmt:       deleteStmt,
+		listByIDs:        listByIDs,
+		search:           search,
 	}, nil
 }
 
@@ -111,3 +135,28 @@ func (a *artifactDB) Delete(ctx context.Context, id string) error {
 	}
 	return nil
 }
+
+// ListByIDs returns the artifacts among ids which belong to botSpaceID.
+func (a *artifactDB) ListByIDs(ctx context.Context, botSpaceID string, ids []string) ([]types.Artifact, error) {
+	artifacts := make([]types.Artifact, 0)
+	if len(ids) == 0 {
+		return artifacts, nil
+	}
+	err := a.listByIDs.SelectContext(ctx, &artifacts, botSpaceID, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list artifacts by id")
+	}
+	return artifacts, nil
+}
+
+// Search returns a page of the space's artifacts matching query, a web
+// search style query, best ranked first. Matches in the name rank above
+// matches in the description, which rank above matches in the data.
+func (a *artifactDB) Search(ctx context.Context, botSpaceID string, query string, limit, offset int) ([]types.ArtifactMatch, error) {
+	matches := make([]types.ArtifactMatch, 0)
+	err := a.search.SelectContext(ctx, &matches, botSpaceID, query, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search artifacts")
+	}
+	return matches, nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+)
+
+// TestSearchLargeArtifact stores an artifact whose data has more distinct
+// words than a tsvector can hold. The insert must succeed, and search only
+// sees the indexed prefix of the data.
+func TestSearchLargeArtifact(t *testing.T) {
+	conf, sdb := dbtest.New(t)
+	ctx := context.Background()
+	artifactDB, err := NewArtifactDB(ctx, conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create artifact db: %v", err)
+	}
+	botSpaceID := dbtest.Space(t, sdb)
+	botID := dbtest.Bot(t, sdb, botSpaceID, "bot")
+
+	var data strings.Builder
+	data.WriteString("kubernetes ")
+	for i := 0; data.Len() < 2<<20; i++ {
+		fmt.Fprintf(&data, "word%d ", i)
+	}
+	data.WriteString("zeppelin")
+
+	now := time.Now()
+	artifact := types.Artifact{
+		ID:             uuid.New().String(),
+		BotSpaceID:     botSpaceID,
+		Name:           "large",
+		Description:    "a large artifact",
+		Data:           data.String(),
+		CreatedByBotID: botID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, err := artifactDB.Insert(ctx, artifact); err != nil {
+		t.Fatalf("failed to insert artifact: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "kubernetes", want: 1},
+		{query: "zeppelin", want: 0},
+	}
+	for _, tt := range tests {
+		matches, err := artifactDB.Search(ctx, botSpaceID, tt.query, 10, 0)
+		if err != nil {
+			t.Fatalf("failed to search for %q: %v", tt.query, err)
+		}
+		if len(matches) != tt.want {
+			t.Errorf("search for %q got %d matches, want %d", tt.query, len(matches), tt.want)
+		}
+		for _, match := range matches {
+			if !strings.Contains(match.Snippet, "**kubernetes**") {
+				t.Errorf("got snippet %q, want the match highlighted", match.Snippet)
+			}
+		}
+	}
+}
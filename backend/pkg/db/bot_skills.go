@@ -10,6 +10,7 @@ import (
 	"github.com/numbergroup/claw-swarm/pkg/config"
 	"github.com/numbergroup/claw-swarm/pkg/types"
 	"github.com/numbergroup/errors"
+	pgvector "github.com/pgvector/pgvector-go"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,6 +23,9 @@ type botSkillDB struct {
 	listByBotSpaceID *sqlx.Stmt
 	update           *sqlx.NamedStmt
 	deleteStmt       *sqlx.Stmt
+	search           *sqlx.Stmt
+	listUnembedded   *sqlx.Stmt
+	setEmbedding     *sqlx.Stmt
 }
 
 func NewBotSkillDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotSkillDB, error) {
@@ -50,7 +54,7 @@ func NewBotSkillDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotS
 
 	update, err := sdb.PrepareNamedContext(ctx, fmt.Sprintf(
 		`UPDATE bot_skills SET name = :name, description = :description, tags = :tags,
-		bot_name = :bot_name, updated_at = :updated_at WHERE id = :id RETURNING %s`, colStr))
+		bot_name = :bot_name, embedding = :embedding, updated_at = :updated_at WHERE id = :id RETURNING %s`, colStr))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to prepare update statement")
 	}
@@ -60,6 +64,28 @@ func NewBotSkillDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotS
 		return nil, errors.Wrap(err, "failed to prepare delete statement")
 	}
 
+	// Zero embeddings, which have a NaN distance to everything, are left
+	// out as in message recall.
+	search, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s, embedding <=> $2 AS distance FROM bot_skills
+		WHERE bot_space_id = $1 AND embedding IS NOT NULL AND NOT 'NaN'::float8 = (embedding <=> $2)
+		ORDER BY embedding <=> $2 LIMIT $3`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare search statement")
+	}
+
+	listUnembedded, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM bot_skills WHERE embedding IS NULL ORDER BY created_at ASC LIMIT $1`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listUnembedded statement")
+	}
+
+	setEmbedding, err := sdb.PreparexContext(ctx,
+		`UPDATE bot_skills SET embedding = $2 WHERE id = $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare setEmbedding statement")
+	}
+
 	return &botSkillDB{
 		db:               sdb,
 		log:              conf.GetLogger(),
@@ -69,6 +95,9 @@ func NewBotSkillDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotS
 		listByBotSpaceID: listByBotSpaceID,
 		update:           update,
 		deleteStmt:       deleteStmt,
+		search:           search,
+		listUnembedded:   listUnembedded,
+		setEmbedding:     setEmbedding,
 	}, nil
 }
 
@@ -115,3 +144,33 @@ func (b *botSkillDB) Delete(ctx context.Context, id string) error {
 	}
 	return nil
 }
+
+// Search returns up to limit skills of the space nearest to embedding by
+// cosine distance, closest first. Skills not embedded yet are skipped.
+func (b *botSkillDB) Search(ctx context.Context, botSpaceID string, embedding pgvector.Vector, limit int) ([]types.SkillMatch, error) {
+	matches := make([]types.SkillMatch, 0)
+	err := b.search.SelectContext(ctx, &matches, botSpaceID, embedding, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search bot skills")
+	}
+	return matches, nil
+}
+
+// ListUnembedded returns up to limit skills of any space which have no
+// embedding, oldest first.
+func (b *botSkillDB) ListUnembedded(ctx context.Context, limit int) ([]types.BotSkill, error) {
+	skills := make([]types.BotSkill, 0)
+	err := b.listUnembedded.SelectContext(ctx, &skills, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list unembedded bot skills")
+	}
+	return skills, nil
+}
+
+func (b *botSkillDB) SetEmbedding(ctx context.Context, id string, embedding pgvector.Vector) error {
+	_, err := b.setEmbedding.ExecContext(ctx, id, embedding)
+	if err != nil {
+		return errors.Wrap(err, "failed to set bot skill embedding")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+	"github.com/numbergroup/claw-swarm/pkg/embed"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/pgvector/pgvector-go"
+)
+
+// TestBotSkillSearchOrder embeds skills with the hash embedder and expects
+// the skills nearest a query first, ignoring other spaces and unembedded
+// skills.
+func TestBotSkillSearchOrder(t *testing.T) {
+	conf, sdb := dbtest.New(t)
+	ctx := context.Background()
+	skillDB, err := NewBotSkillDB(ctx, conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create bot skill db: %v", err)
+	}
+	embedder := embed.NewHashEmbedder()
+
+	insert := func(botSpaceID, name string, embedded bool) string {
+		t.Helper()
+		botID := dbtest.Bot(t, sdb, botSpaceID, "bot")
+		now := time.Now()
+		skill := types.BotSkill{
+			ID:          uuid.New().String(),
+			BotSpaceID:  botSpaceID,
+			BotID:       botID,
+			BotName:     "bot",
+			Name:        name,
+			Description: name,
+			Tags:        pq.StringArray{},
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if embedded {
+			embeddings, err := embedder.Embed(ctx, []string{name})
+			if err != nil {
+				t.Fatalf("failed to embed skill: %v", err)
+			}
+			vec := pgvector.NewVector(embeddings[0])
+			skill.Embedding = &vec
+		}
+		if _, err := skillDB.Insert(ctx, skill); err != nil {
+			t.Fatalf("failed to insert skill: %v", err)
+		}
+		return skill.ID
+	}
+
+	botSpaceID := dbtest.Space(t, sdb)
+	exact := insert(botSpaceID, "deploy services to kubernetes", true)
+	partial := insert(botSpaceID, "deploy static websites", true)
+	unrelated := insert(botSpaceID, "bake chocolate cakes", true)
+	insert(botSpaceID, "deploy services to kubernetes clusters", false)
+	insert(dbtest.Space(t, sdb), "deploy services to kubernetes", true)
+
+	query, err := embedder.Embed(ctx, []string{"deploy services to kubernetes"})
+	if err != nil {
+		t.Fatalf("failed to embed query: %v", err)
+	}
+	matches, err := skillDB.Search(ctx, botSpaceID, pgvector.NewVector(query[0]), 10)
+	if err != nil {
+		t.Fatalf("failed to search skills: %v", err)
+	}
+
+	want := []string{exact, partial, unrelated}
+	if len(matches) != len(want) {
+		t.Fatalf("got %d matches, want %d", len(matches), len(want))
+	}
+	for i, match := range matches {
+		if match.ID != want[i] {
+			t.Errorf("match %d is %s (%q), want %s", i, match.ID, match.Name, want[i])
+		}
+		if i > 0 && match.Distance < matches[i-1].Distance {
+			t.Errorf("match %d is nearer than match %d", i, i-1)
+		}
+	}
+	if matches[0].Distance > 1e-5 {
+		t.Errorf("got distance %v for an identical skill, want 0", matches[0].Distance)
+	}
+
+	limited, err := skillDB.Search(ctx, botSpaceID, pgvector.NewVector(query[0]), 1)
+	if err != nil {
+		t.Fatalf("failed to search skills: %v", err)
+	}
+	if len(limited) != 1 || limited[0].ID != exact {
+		t.Errorf("got %d matches with a limit of 1, want only the identical skill", len(limited))
+	}
+}
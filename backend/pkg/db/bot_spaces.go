@@ -68,8 +68,8 @@ func NewBotSpaceDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotS
 	}
 
 	update, err := sdb.PreparexContext(ctx, fmt.Sprintf(
-		`UPDATE bot_spaces SET name = $1, description = $2, updated_at = now()
-		WHERE id = $3 RETURNING %s`, colStr))
+		`UPDATE bot_spaces SET name = $1, description = $2, requires_task_review = $3, updated_at = now()
+		WHERE id = $4 RETURNING %s`, colStr))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to prepare update statement")
 	}
@@ -151,9 +151,9 @@ func (b *botSpaceDB) Insert(ctx context.Context, botSpace types.BotSpace) (strin
 	return id, nil
 }
 
-func (b *botSpaceDB) Update(ctx context.Context, id string, name string, description *string) (types.BotSpace, error) {
+func (b *botSpaceDB) Update(ctx context.Context, id string, name string, description *string, requiresTaskReview bool) (types.BotSpace, error) {
 	var bs types.BotSpace
-	err := b.update.GetContext(ctx, &bs, name, description, id)
+	err := b.update.GetContext(ctx, &bs, name, description, requiresTaskReview, id)
 	if err != nil {
 		return bs, errors.Wrap(err, "failed to update bot space")
 	}
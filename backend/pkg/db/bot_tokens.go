@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/innodv/psql"
+	"github.com/jmoiron/sqlx"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/errors"
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+type botTokenDB struct {
+	db                  *sqlx.DB
+	log                 logrus.Ext1FieldLogger
+	conf                *config.Config
+	insert              *sqlx.NamedStmt
+	isActive            *sqlx.Stmt
+	revoke              *sqlx.Stmt
+	revokeByBotID       *sqlx.Stmt
+	revokeByBotSpaceID  *sqlx.Stmt
+	cutoffByBotID       *sqlx.Stmt
+	cutoffByBotSpaceID  *sqlx.Stmt
+	retireLegacy        *sqlx.Stmt
+	deleteExpiredBefore *sqlx.Stmt
+	activeCache         *gocache.Cache
+}
+
+func NewBotTokenDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotTokenDB, error) {
+	cols := psql.GetSQLColumnsQuoted[types.BotToken]()
+	colStr := strings.Join(cols, ", ")
+	rawCols := psql.GetSQLColumns[types.BotToken]()
+
+	insert, err := sdb.PrepareNamedContext(ctx, fmt.Sprintf(
+		`INSERT INTO bot_tokens (%s) VALUES (:%s)`,
+		colStr, strings.Join(rawCols, ", :")))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare insert statement")
+	}
+
+	isActive, err := sdb.PreparexContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM bot_tokens WHERE id = $1 AND revoked_at IS NULL AND expires_at > now())`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare isActive statement")
+	}
+
+	revoke, err := sdb.PreparexContext(ctx,
+		`UPDATE bot_tokens SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare revoke statement")
+	}
+
+	revokeByBotID, err := sdb.PreparexContext(ctx,
+		`UPDATE bot_tokens SET revoked_at = now() WHERE bot_id = $1 AND revoked_at IS NULL AND expires_at > now()`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare revokeByBotID statement")
+	}
+
+	revokeByBotSpaceID, err := sdb.PreparexContext(ctx,
+		`UPDATE bot_tokens SET revoked_at = now() WHERE bot_space_id = $1 AND revoked_at IS NULL AND expires_at > now()`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare revokeByBotSpaceID statement")
+	}
+
+	// Bulk revocation also moves the bots' token cutoff, which catches tokens
+	// without a jti, and notifies every replica so cached authorization is
+	// dropped when it commits. A token's iat is in whole seconds, so the
+	// cutoff is the start of the next second: tokens issued earlier in the
+	// same second as the revocation stop working too. It never moves back.
+	cutoffByBotID, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH updated AS (
+			UPDATE bots SET tokens_revoked_before = GREATEST(tokens_revoked_before, date_trunc('second', now()) + interval '1 second')
+			WHERE id = $1 RETURNING id
+		)
+		SELECT pg_notify('%s', id::text) FROM updated`, botAuthChannel))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare cutoffByBotID statement")
+	}
+
+	cutoffByBotSpaceID, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH updated AS (
+			UPDATE bots SET tokens_revoked_before = GREATEST(tokens_revoked_before, date_trunc('second', now()) + interval '1 second')
+			WHERE bot_space_id = $1 RETURNING id
+		)
+		SELECT pg_notify('%s', id::text) FROM updated`, botAuthChannel))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare cutoffByBotSpaceID statement")
+	}
+
+	retireLegacy, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH updated AS (
+			UPDATE bots SET tokens_revoked_before = $2
+			WHERE id = $1 AND (tokens_revoked_before IS NULL OR tokens_revoked_before < $2) RETURNING id
+		)
+		SELECT pg_notify('%s', id::text) FROM updated`, botAuthChannel))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare retireLegacy statement")
+	}
+
+	deleteExpiredBefore, err := sdb.PreparexContext(ctx,
+		`DELETE FROM bot_tokens WHERE expires_at < $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare deleteExpiredBefore statement")
+	}
+
+	return &botTokenDB{
+		db:                  sdb,
+		log:                 conf.GetLogger(),
+		conf:                conf,
+		insert:              insert,
+		isActive:            isActive,
+		revoke:              revoke,
+		revokeByBotID:       revokeByBotID,
+		revokeByBotSpaceID:  revokeByBotSpaceID,
+		cutoffByBotID:       cutoffByBotID,
+		cutoffByBotSpaceID:  cutoffByBotSpaceID,
+		retireLegacy:        retireLegacy,
+		deleteExpiredBefore: deleteExpiredBefore,
+		activeCache:         gocache.New(conf.BotAuthCacheTTL, 2*conf.BotAuthCacheTTL),
+	}, nil
+}
+
+func (b *botTokenDB) Insert(ctx context.Context, token types.BotToken) error {
+	_, err := b.insert.ExecContext(ctx, token)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert bot token")
+	}
+	return nil
+}
+
+// IsActive reports whether the token with jti id may be used. Active tokens
+// are cached for BOT_AUTH_CACHE_TTL; bulk revocation takes effect at once
+// through the bot's token cutoff instead.
+func (b *botTokenDB) IsActive(ctx context.Context, id string) (bool, error) {
+	if _, ok := b.activeCache.Get(id); ok {
+		return true, nil
+	}
+
+	var active bool
+	err := b.isActive.GetContext(ctx, &active, id)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check bot token")
+	}
+	if active {
+		b.activeCache.SetDefault(id, struct{}{})
+	}
+	return active, nil
+}
+
+func (b *botTokenDB) Revoke(ctx context.Context, id string) error {
+	defer b.activeCache.Delete(id)
+	_, err := b.revoke.ExecContext(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to revoke bot token")
+	}
+	return nil
+}
+
+// RevokeByBotID revokes every token of a bot, including tokens without a
+// jti, and returns how many tracked tokens were revoked.
+func (b *botTokenDB) RevokeByBotID(ctx context.Context, botID string) (int64, error) {
+	return b.revokeAll(ctx, b.revokeByBotID, b.cutoffByBotID, botID)
+}
+
+// RevokeByBotSpaceID revokes every token of every bot in a space, including
+// tokens without a jti, and returns how many tracked tokens were revoked.
+func (b *botTokenDB) RevokeByBotSpaceID(ctx context.Context, botSpaceID string) (int64, error) {
+	return b.revokeAll(ctx, b.revokeByBotSpaceID, b.cutoffByBotSpaceID, botSpaceID)
+}
+
+func (b *botTokenDB) revokeAll(ctx context.Context, revoke, cutoff *sqlx.Stmt, id string) (int64, error) {
+	tx, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.StmtxContext(ctx, revoke).ExecContext(ctx, id)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to revoke bot tokens")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get rows affected")
+	}
+
+	if _, err := tx.StmtxContext(ctx, cutoff).ExecContext(ctx, id); err != nil {
+		return 0, errors.Wrap(err, "failed to set bot token cutoff")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "failed to commit transaction")
+	}
+	return affected, nil
+}
+
+// RetireLegacy moves a bot's token cutoff past a token without a jti which
+// was issued at issuedAt, so that token and any issued before it stop
+// working. It reports false if the cutoff was already past it, which makes
+// exchanging each such token a one-time operation.
+func (b *botTokenDB) RetireLegacy(ctx context.Context, botID string, issuedAt time.Time) (bool, error) {
+	result, err := b.retireLegacy.ExecContext(ctx, botID, issuedAt.Truncate(time.Second).Add(time.Second))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to retire legacy bot token")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get rows affected")
+	}
+	return affected > 0, nil
+}
+
+func (b *botTokenDB) DeleteExpiredBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := b.deleteExpiredBefore.ExecContext(ctx, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired bot tokens")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get rows affected")
+	}
+	return affected, nil
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+)
+
+type tokenFixture struct {
+	db         BotTokenDB
+	sdb        *sqlx.DB
+	botSpaceID string
+}
+
+func newTokenFixture(t *testing.T) tokenFixture {
+	t.Helper()
+	conf, sdb := dbtest.New(t)
+	tokenDB, err := NewBotTokenDB(context.Background(), conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create bot token db: %v", err)
+	}
+	return tokenFixture{db: tokenDB, sdb: sdb, botSpaceID: dbtest.Space(t, sdb)}
+}
+
+// issue records a token for botID issued now and returns its jti.
+func (f tokenFixture) issue(t *testing.T, botID string) string {
+	t.Helper()
+	now := time.Now()
+	token := types.BotToken{
+		ID:         uuid.New().String(),
+		BotID:      botID,
+		BotSpaceID: f.botSpaceID,
+		IssuedAt:   now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	if err := f.db.Insert(context.Background(), token); err != nil {
+		t.Fatalf("failed to insert token: %v", err)
+	}
+	return token.ID
+}
+
+func (f tokenFixture) expectActive(t *testing.T, jti string, want bool) {
+	t.Helper()
+	active, err := f.db.IsActive(context.Background(), jti)
+	if err != nil {
+		t.Fatalf("failed to check token: %v", err)
+	}
+	if active != want {
+		t.Errorf("token %s active = %v, want %v", jti, active, want)
+	}
+}
+
+// cutoff reads botID's token cutoff straight from the table.
+func (f tokenFixture) cutoff(t *testing.T, botID string) *time.Time {
+	t.Helper()
+	var cutoff *time.Time
+	if err := f.sdb.Get(&cutoff, `SELECT tokens_revoked_before FROM bots WHERE id = $1`, botID); err != nil {
+		t.Fatalf("failed to read token cutoff: %v", err)
+	}
+	return cutoff
+}
+
+func (f tokenFixture) dbNow(t *testing.T) time.Time {
+	t.Helper()
+	var now time.Time
+	if err := f.sdb.Get(&now, `SELECT now()`); err != nil {
+		t.Fatalf("failed to read database time: %v", err)
+	}
+	return now
+}
+
+func TestRevokeToken(t *testing.T) {
+	f := newTokenFixture(t)
+	botID := dbtest.Bot(t, f.sdb, f.botSpaceID, "bot")
+	revoked, kept := f.issue(t, botID), f.issue(t, botID)
+
+	// Cache the token as active first; revoking it must not leave it cached.
+	f.expectActive(t, revoked, true)
+	if err := f.db.Revoke(context.Background(), revoked); err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+	f.expectActive(t, revoked, false)
+	f.expectActive(t, kept, true)
+	if cutoff := f.cutoff(t, botID); cutoff != nil {
+		t.Errorf("revoking one token set the bot's cutoff to %v", cutoff)
+	}
+}
+
+func TestRevokeAllTokens(t *testing.T) {
+	f := newTokenFixture(t)
+	ctx := context.Background()
+	botID := dbtest.Bot(t, f.sdb, f.botSpaceID, "bot")
+	otherID := dbtest.Bot(t, f.sdb, f.botSpaceID, "other")
+	tokens := []string{f.issue(t, botID), f.issue(t, botID)}
+	other := f.issue(t, otherID)
+
+	before := f.dbNow(t)
+	revoked, err := f.db.RevokeByBotID(ctx, botID)
+	if err != nil {
+		t.Fatalf("failed to revoke tokens: %v", err)
+	}
+	after := f.dbNow(t)
+
+	if revoked != int64(len(tokens)) {
+		t.Errorf("revoked %d tokens, want %d", revoked, len(tokens))
+	}
+	for _, jti := range tokens {
+		f.expectActive(t, jti, false)
+	}
+	f.expectActive(t, other, true)
+	if cutoff := f.cutoff(t, otherID); cutoff != nil {
+		t.Errorf("revoking another bot's tokens set this bot's cutoff to %v", cutoff)
+	}
+
+	// A token's iat is in whole seconds, so one issued earlier in the same
+	// second as the revocation has an iat equal to the truncated time. The
+	// cutoff must be past it.
+	cutoff := f.cutoff(t, botID)
+	if cutoff == nil {
+		t.Fatal("revoking all tokens did not set the bot's cutoff")
+	}
+	sameSecond := before.Truncate(time.Second)
+	if !sameSecond.Before(*cutoff) {
+		t.Errorf("cutoff %v does not reject a token issued at %v", cutoff, sameSecond)
+	}
+	if latest := after.Truncate(time.Second).Add(time.Second); cutoff.After(latest) {
+		t.Errorf("cutoff %v is later than %v", cutoff, latest)
+	}
+
+	// Revoking again never moves the cutoff back.
+	if _, err := f.sdb.Exec(`UPDATE bots SET tokens_revoked_before = now() + interval '1 hour' WHERE id = $1`, botID); err != nil {
+		t.Fatalf("failed to move cutoff: %v", err)
+	}
+	future := f.cutoff(t, botID)
+	if _, err := f.db.RevokeByBotID(ctx, botID); err != nil {
+		t.Fatalf("failed to revoke tokens: %v", err)
+	}
+	if cutoff := f.cutoff(t, botID); !cutoff.Equal(*future) {
+		t.Errorf("revoking again moved the cutoff from %v to %v", future, cutoff)
+	}
+}
+
+func TestRevokeAllTokensInSpace(t *testing.T) {
+	f := newTokenFixture(t)
+	botIDs := []string{dbtest.Bot(t, f.sdb, f.botSpaceID, "a"), dbtest.Bot(t, f.sdb, f.botSpaceID, "b")}
+	tokens := []string{f.issue(t, botIDs[0]), f.issue(t, botIDs[1])}
+
+	otherSpace := tokenFixture{db: f.db, sdb: f.sdb, botSpaceID: dbtest.Space(t, f.sdb)}
+	otherBot := dbtest.Bot(t, f.sdb, otherSpace.botSpaceID, "c")
+	other := otherSpace.issue(t, otherBot)
+
+	revoked, err := f.db.RevokeByBotSpaceID(context.Background(), f.botSpaceID)
+	if err != nil {
+		t.Fatalf("failed to revoke tokens: %v", err)
+	}
+	if revoked != 2 {
+		t.Errorf("revoked %d tokens, want 2", revoked)
+	}
+	for i, jti := range tokens {
+		f.expectActive(t, jti, false)
+		if f.cutoff(t, botIDs[i]) == nil {
+			t.Errorf("bot %d has no token cutoff", i)
+		}
+	}
+	f.expectActive(t, other, true)
+	if cutoff := f.cutoff(t, otherBot); cutoff != nil {
+		t.Errorf("revoking another space's tokens set a cutoff of %v", cutoff)
+	}
+}
+
+// TestRetireLegacy exchanges a token without a jti, which must only work
+// once.
+func TestRetireLegacy(t *testing.T) {
+	f := newTokenFixture(t)
+	ctx := context.Background()
+	botID := dbtest.Bot(t, f.sdb, f.botSpaceID, "bot")
+	issuedAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+	retired, err := f.db.RetireLegacy(ctx, botID, issuedAt)
+	if err != nil {
+		t.Fatalf("failed to retire legacy token: %v", err)
+	}
+	if !retired {
+		t.Fatal("first exchange of a legacy token was refused")
+	}
+	cutoff := f.cutoff(t, botID)
+	if cutoff == nil || !issuedAt.Before(*cutoff) {
+		t.Fatalf("got cutoff %v, want one past %v", cutoff, issuedAt)
+	}
+	if !cutoff.Before(time.Now().Add(-time.Hour)) {
+		t.Errorf("cutoff %v would also reject tokens issued since", cutoff)
+	}
+
+	for _, at := range []time.Time{issuedAt, issuedAt.Add(-time.Hour)} {
+		retired, err := f.db.RetireLegacy(ctx, botID, at)
+		if err != nil {
+			t.Fatalf("failed to retire legacy token: %v", err)
+		}
+		if retired {
+			t.Errorf("legacy token issued at %v was exchanged after the cutoff passed it", at)
+		}
+	}
+
+	// A later legacy token can still be exchanged once.
+	retired, err = f.db.RetireLegacy(ctx, botID, issuedAt.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to retire legacy token: %v", err)
+	}
+	if !retired {
+		t.Error("a later legacy token was refused")
+	}
+}
@@ -4,15 +4,20 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/innodv/psql"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/numbergroup/claw-swarm/pkg/config"
 	"github.com/numbergroup/claw-swarm/pkg/types"
 	"github.com/numbergroup/errors"
+	gocache "github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 )
 
+const botAuthChannel = "claw_swarm_bot_auth"
+
 type botDB struct {
 	db               *sqlx.DB
 	log              logrus.Ext1FieldLogger
@@ -21,9 +26,13 @@ type botDB struct {
 	listByBotSpaceID *sqlx.Stmt
 	insert           *sqlx.NamedStmt
 	deleteStmt       *sqlx.Stmt
+	releaseTasks     *sqlx.Stmt
 	setManager       *sqlx.Stmt
 	setMuted         *sqlx.Stmt
 	updateLastSeen   *sqlx.Stmt
+	listLastSeen     *sqlx.Stmt
+	getAuth          *sqlx.Stmt
+	authCache        *gocache.Cache
 }
 
 func NewBotDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotDB, error) {
@@ -50,20 +59,45 @@ func NewBotDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotDB, er
 		return nil, errors.Wrap(err, "failed to prepare insert statement")
 	}
 
-	deleteStmt, err := sdb.PreparexContext(ctx,
-		`DELETE FROM bots WHERE id = $1`)
+	// Statements which change what a bot is allowed to do notify every
+	// replica so cached authorization is dropped when they commit.
+	deleteStmt, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH deleted AS (DELETE FROM bots WHERE id = $1 RETURNING id)
+		SELECT pg_notify('%s', id::text) FROM deleted`, botAuthChannel))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to prepare delete statement")
 	}
 
-	setManager, err := sdb.PreparexContext(ctx,
-		`UPDATE bots SET is_manager = $1, updated_at = now() WHERE id = $2`)
+	// space_tasks.bot_id is set to NULL when a bot is deleted, which would
+	// leave its task in progress with nobody to finish it.
+	taskCols := psql.GetSQLColumnsQuoted[types.SpaceTask]()
+	taskColStr := strings.Join(taskCols, ", ")
+	releaseTasks, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH released AS (
+			UPDATE space_tasks SET status = 'available', bot_id = NULL, lease_expires_at = NULL,
+			updated_at = now(), version = version + 1
+			WHERE bot_id = $1 AND status = 'in_progress'
+			RETURNING %s
+		), logged AS (
+			INSERT INTO task_events (task_id, bot_space_id, type, actor_type, from_status, to_status, note)
+			SELECT id, bot_space_id, 'released', 'system', 'in_progress', status, 'bot was removed'
+			FROM released
+		)
+		SELECT %s FROM released`, taskColStr, taskColStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare releaseTasks statement")
+	}
+
+	setManager, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH updated AS (UPDATE bots SET is_manager = $1, updated_at = now() WHERE id = $2 RETURNING id)
+		SELECT pg_notify('%s', id::text) FROM updated`, botAuthChannel))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to prepare setManager statement")
 	}
 
-	setMuted, err := sdb.PreparexContext(ctx,
-		`UPDATE bots SET is_muted = $1, updated_at = now() WHERE id = $2`)
+	setMuted, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH updated AS (UPDATE bots SET is_muted = $1, updated_at = now() WHERE id = $2 RETURNING id)
+		SELECT pg_notify('%s', id::text) FROM updated`, botAuthChannel))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to prepare setMuted statement")
 	}
@@ -74,6 +108,18 @@ func NewBotDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotDB, er
 		return nil, errors.Wrap(err, "failed to prepare updateLastSeen statement")
 	}
 
+	listLastSeen, err := sdb.PreparexContext(ctx,
+		`SELECT id, last_seen_at FROM bots WHERE id = ANY($1) AND last_seen_at IS NOT NULL`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listLastSeen statement")
+	}
+
+	getAuth, err := sdb.PreparexContext(ctx,
+		`SELECT id, bot_space_id, name, is_manager, is_muted, tokens_revoked_before FROM bots WHERE id = $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare getAuth statement")
+	}
+
 	return &botDB{
 		db:               sdb,
 		log:              conf.GetLogger(),
@@ -82,9 +128,13 @@ func NewBotDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (BotDB, er
 		listByBotSpaceID: listByBotSpaceID,
 		insert:           insert,
 		deleteStmt:       deleteStmt,
+		releaseTasks:     releaseTasks,
 		setManager:       setManager,
 		setMuted:         setMuted,
 		updateLastSeen:   updateLastSeen,
+		listLastSeen:     listLastSeen,
+		getAuth:          getAuth,
+		authCache:        gocache.New(conf.BotAuthCacheTTL, 2*conf.BotAuthCacheTTL),
 	}, nil
 }
 
@@ -115,15 +165,34 @@ func (b *botDB) Insert(ctx context.Context, bot types.Bot) (string, error) {
 	return id, nil
 }
 
-func (b *botDB) Delete(ctx context.Context, id string) error {
-	_, err := b.deleteStmt.ExecContext(ctx, id)
+// Delete removes the bot and, in the same transaction, makes the task it
+// had in progress available again. It returns the released tasks.
+func (b *botDB) Delete(ctx context.Context, id string) ([]types.SpaceTask, error) {
+	defer b.authCache.Delete(id)
+	tx, err := b.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return errors.Wrap(err, "failed to delete bot")
+		return nil, errors.Wrap(err, "failed to begin transaction")
 	}
-	return nil
+	defer tx.Rollback()
+
+	released := make([]types.SpaceTask, 0)
+	err = tx.StmtxContext(ctx, b.releaseTasks).SelectContext(ctx, &released, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to release bot tasks")
+	}
+
+	if _, err := tx.StmtxContext(ctx, b.deleteStmt).ExecContext(ctx, id); err != nil {
+		return nil, errors.Wrap(err, "failed to delete bot")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "failed to commit bot deletion")
+	}
+	return released, nil
 }
 
 func (b *botDB) SetManager(ctx context.Context, id string, isManager bool) error {
+	defer b.authCache.Delete(id)
 	_, err := b.setManager.ExecContext(ctx, isManager, id)
 	if err != nil {
 		return errors.Wrap(err, "failed to set manager")
@@ -132,6 +201,7 @@ func (b *botDB) SetManager(ctx context.Context, id string, isManager bool) error
 }
 
 func (b *botDB) SetMuted(ctx context.Context, id string, isMuted bool) error {
+	defer b.authCache.Delete(id)
 	_, err := b.setMuted.ExecContext(ctx, isMuted, id)
 	if err != nil {
 		return errors.Wrap(err, "failed to set muted")
@@ -139,6 +209,22 @@ func (b *botDB) SetMuted(ctx context.Context, id string, isMuted bool) error {
 	return nil
 }
 
+// GetAuth returns the live role, mute state and token revocation cutoff of
+// a bot, cached for BOT_AUTH_CACHE_TTL.
+func (b *botDB) GetAuth(ctx context.Context, id string) (types.BotAuth, error) {
+	if cached, ok := b.authCache.Get(id); ok {
+		return cached.(types.BotAuth), nil
+	}
+
+	var auth types.BotAuth
+	err := b.getAuth.GetContext(ctx, &auth, id)
+	if err != nil {
+		return auth, errors.Wrap(err, "failed to get bot auth")
+	}
+	b.authCache.SetDefault(id, auth)
+	return auth, nil
+}
+
 func (b *botDB) UpdateLastSeen(ctx context.Context, id string) error {
 	_, err := b.updateLastSeen.ExecContext(ctx, id)
 	if err != nil {
@@ -146,3 +232,60 @@ func (b *botDB) UpdateLastSeen(ctx context.Context, id string) error {
 	}
 	return nil
 }
+
+// ListLastSeen returns the persisted last seen time of each of ids which has
+// one.
+func (b *botDB) ListLastSeen(ctx context.Context, ids []string) (map[string]time.Time, error) {
+	var rows []struct {
+		ID         string    `db:"id"`
+		LastSeenAt time.Time `db:"last_seen_at"`
+	}
+	err := b.listLastSeen.SelectContext(ctx, &rows, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list last seen times")
+	}
+
+	lastSeen := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		lastSeen[row.ID] = row.LastSeenAt
+	}
+	return lastSeen, nil
+}
+
+// WatchAuthInvalidations drops cached authorization when another replica
+// changes a bot, until ctx is done. Without it, changes made elsewhere take
+// up to BOT_AUTH_CACHE_TTL to apply here.
+func (b *botDB) WatchAuthInvalidations(ctx context.Context) error {
+	connStr, err := b.conf.PSQLConnString()
+	if err != nil {
+		return errors.Wrap(err, "failed to build listener connection string")
+	}
+
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			b.log.WithError(err).WithField("event", ev).Warn("bot auth listener event")
+		}
+	})
+	if err := listener.Listen(botAuthChannel); err != nil {
+		return errors.Wrap(err, "failed to listen on bot auth channel")
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification follows a reconnect; anything sent while
+				// disconnected was missed, so start over.
+				if n == nil {
+					b.authCache.Flush()
+					continue
+				}
+				b.authCache.Delete(n.Extra)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+)
+
+type botFixture struct {
+	sdb        *sqlx.DB
+	bots       BotDB
+	tasks      SpaceTaskDB
+	botSpaceID string
+	botID      string
+}
+
+func newBotFixture(t *testing.T) botFixture {
+	t.Helper()
+	conf, sdb := dbtest.New(t)
+	ctx := context.Background()
+
+	bots, err := NewBotDB(ctx, conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create bot db: %v", err)
+	}
+	tasks, err := NewSpaceTaskDB(ctx, conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create space task db: %v", err)
+	}
+	f := botFixture{sdb: sdb, bots: bots, tasks: tasks, botSpaceID: dbtest.Space(t, sdb)}
+	f.botID = dbtest.Bot(t, sdb, f.botSpaceID, "bot")
+	return f
+}
+
+// leasedTask inserts a task in progress with the fixture's bot, whose lease
+// expires at leaseExpiresAt.
+func (f botFixture) leasedTask(t *testing.T, leaseExpiresAt time.Time) types.SpaceTask {
+	t.Helper()
+	now := time.Now()
+	task := types.SpaceTask{
+		ID:             uuid.New().String(),
+		BotSpaceID:     f.botSpaceID,
+		Name:           "leased",
+		Status:         types.TaskStatusInProgress,
+		BotID:          &f.botID,
+		LeaseExpiresAt: &leaseExpiresAt,
+		CreatedByType:  types.ActorBot,
+		CreatedByID:    f.botID,
+		Version:        1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		RequiredSkills: pq.StringArray{},
+		DependsOn:      []string{},
+	}
+	inserted, err := f.tasks.Insert(context.Background(), task, taskEvent(task, types.TaskEventCreated))
+	if err != nil {
+		t.Fatalf("failed to insert task: %v", err)
+	}
+	return inserted
+}
+
+// TestDeleteReleasesActiveTask removes a bot holding a task; the task must
+// be available again rather than left in progress with no bot.
+func TestDeleteReleasesActiveTask(t *testing.T) {
+	f := newBotFixture(t)
+	ctx := context.Background()
+	task := f.leasedTask(t, time.Now().Add(time.Hour))
+
+	released, err := f.bots.Delete(ctx, f.botID)
+	if err != nil {
+		t.Fatalf("failed to delete bot: %v", err)
+	}
+	if len(released) != 1 || released[0].ID != task.ID {
+		t.Fatalf("got released tasks %+v, want only %s", released, task.ID)
+	}
+
+	got, err := f.tasks.GetByID(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("failed to get task: %v", err)
+	}
+	if got.Status != types.TaskStatusAvailable || got.BotID != nil || got.LeaseExpiresAt != nil {
+		t.Errorf("got task status %q, bot %v, lease %v; want available with no bot or lease", got.Status, got.BotID, got.LeaseExpiresAt)
+	}
+
+	events, err := f.tasks.ListEvents(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	if last := events[len(events)-1]; last.Type != types.TaskEventReleased || last.ActorType != types.ActorSystem {
+		t.Errorf("got last event %q by %q, want a system released event", last.Type, last.ActorType)
+	}
+}
+
+// TestReleaseExpiredAfterBotDeleted reaps a lapsed lease whose bot was
+// deleted without releasing it, as deletes did before Delete released
+// tasks. The rest of the batch must still be released.
+func TestReleaseExpiredAfterBotDeleted(t *testing.T) {
+	f := newBotFixture(t)
+	ctx := context.Background()
+	orphaned := f.leasedTask(t, time.Now().Add(-time.Minute))
+	if _, err := f.sdb.ExecContext(ctx, `DELETE FROM bots WHERE id = $1`, f.botID); err != nil {
+		t.Fatalf("failed to delete bot: %v", err)
+	}
+
+	f.botID = dbtest.Bot(t, f.sdb, f.botSpaceID, "other")
+	held := f.leasedTask(t, time.Now().Add(-time.Minute))
+
+	released, err := f.tasks.ReleaseExpired(ctx, 10)
+	if err != nil {
+		t.Fatalf("failed to release expired tasks: %v", err)
+	}
+	if len(released) != 2 {
+		t.Fatalf("got %d released tasks, want 2", len(released))
+	}
+	for _, task := range released {
+		switch task.ID {
+		case orphaned.ID:
+			if task.ReleasedBotID != nil {
+				t.Errorf("got released bot %s for the orphaned task, want nil", *task.ReleasedBotID)
+			}
+		case held.ID:
+			if task.ReleasedBotID == nil || *task.ReleasedBotID != f.botID {
+				t.Errorf("got released bot %v, want %s", task.ReleasedBotID, f.botID)
+			}
+		default:
+			t.Errorf("released unexpected task %s", task.ID)
+		}
+		if task.Status != types.TaskStatusAvailable {
+			t.Errorf("got task status %q, want %q", task.Status, types.TaskStatusAvailable)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"github.com/numbergroup/claw-swarm/pkg/types"
+	pgvector "github.com/pgvector/pgvector-go"
 )
 
 type UserDB interface {
@@ -18,7 +20,7 @@ type BotSpaceDB interface {
 	ListByUserID(ctx context.Context, userID string) ([]types.BotSpace, error)
 	GetByJoinCode(ctx context.Context, joinCode string) (types.BotSpace, error)
 	Insert(ctx context.Context, botSpace types.BotSpace) (string, error)
-	Update(ctx context.Context, id string, name string, description *string) (types.BotSpace, error)
+	Update(ctx context.Context, id string, name string, description *string, requiresTaskReview bool) (types.BotSpace, error)
 	Delete(ctx context.Context, id string) error
 	UpdateJoinCodes(ctx context.Context, id string, joinCode string, managerJoinCode string) (types.BotSpace, error)
 	SetManagerBotID(ctx context.Context, id string, botID string) error
@@ -36,10 +38,23 @@ type BotDB interface {
 	GetByID(ctx context.Context, id string) (types.Bot, error)
 	ListByBotSpaceID(ctx context.Context, botSpaceID string) ([]types.Bot, error)
 	Insert(ctx context.Context, bot types.Bot) (string, error)
-	Delete(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) ([]types.SpaceTask, error)
 	SetManager(ctx context.Context, id string, isManager bool) error
 	SetMuted(ctx context.Context, id string, isMuted bool) error
 	UpdateLastSeen(ctx context.Context, id string) error
+	ListLastSeen(ctx context.Context, ids []string) (map[string]time.Time, error)
+	GetAuth(ctx context.Context, id string) (types.BotAuth, error)
+	WatchAuthInvalidations(ctx context.Context) error
+}
+
+type BotTokenDB interface {
+	Insert(ctx context.Context, token types.BotToken) error
+	IsActive(ctx context.Context, id string) (bool, error)
+	Revoke(ctx context.Context, id string) error
+	RevokeByBotID(ctx context.Context, botID string) (int64, error)
+	RevokeByBotSpaceID(ctx context.Context, botSpaceID string) (int64, error)
+	RetireLegacy(ctx context.Context, botID string, issuedAt time.Time) (bool, error)
+	DeleteExpiredBefore(ctx context.Context, before time.Time) (int64, error)
 }
 
 type MessageDB interface {
@@ -48,6 +63,12 @@ type MessageDB interface {
 	ListSince(ctx context.Context, botSpaceID string, sinceID string, limit int) ([]types.Message, error)
 	ListSpaceIDsExceedingCount(ctx context.Context, maxCount int) ([]string, error)
 	DeleteOlderThanNth(ctx context.Context, botSpaceID string, keep int) (int64, error)
+	Search(ctx context.Context, botSpaceID string, query string, limit, offset int) ([]types.MessageMatch, error)
+	ListUnembedded(ctx context.Context, limit int, maxAttempts int, failedBefore time.Time) ([]types.Message, error)
+	SetEmbedding(ctx context.Context, id string, embedding *pgvector.Vector) error
+	RecordEmbedFailure(ctx context.Context, id string) error
+	Recall(ctx context.Context, botSpaceID string, embedding pgvector.Vector, limit int) ([]types.RecalledMessage, error)
+	WithEmbedderLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
 }
 
 type BotStatusDB interface {
@@ -70,16 +91,26 @@ type InviteCodeDB interface {
 }
 
 type SpaceTaskDB interface {
-	Insert(ctx context.Context, task types.SpaceTask) (types.SpaceTask, error)
+	Insert(ctx context.Context, task types.SpaceTask, event types.TaskEvent) (types.SpaceTask, error)
 	GetByID(ctx context.Context, id string) (types.SpaceTask, error)
-	ListByBotSpaceID(ctx context.Context, botSpaceID string, status *string) ([]types.SpaceTask, error)
+	ListByBotSpaceID(ctx context.Context, botSpaceID string, filter types.TaskFilter) ([]types.SpaceTask, error)
 	GetActiveByBotID(ctx context.Context, botSpaceID string, botID string) (*types.SpaceTask, error)
-	Update(ctx context.Context, task types.SpaceTask) (types.SpaceTask, error)
+	Update(ctx context.Context, task types.SpaceTask, event types.TaskEvent) (types.SpaceTask, error)
+	Finish(ctx context.Context, task types.SpaceTask, event types.TaskEvent) (types.SpaceTask, []types.SpaceTask, error)
+	ListSubtree(ctx context.Context, rootID string) ([]types.SpaceTask, error)
+	ClaimNext(ctx context.Context, botSpaceID string, botID string, leaseExpiresAt time.Time) (*types.SpaceTask, error)
+	RenewLease(ctx context.Context, taskID string, botID string, leaseExpiresAt time.Time) (types.SpaceTask, error)
+	ReleaseExpired(ctx context.Context, limit int) ([]types.ReleasedTask, error)
+	ListEvents(ctx context.Context, taskID string) ([]types.TaskEvent, error)
+	InsertFromSchedule(ctx context.Context, task types.SpaceTask, event types.TaskEvent, schedule types.TaskSchedule, nextRunAt time.Time) (bool, error)
+	ListBotLoads(ctx context.Context, botSpaceID string) ([]types.BotTaskLoad, error)
 }
 
 type ArtifactDB interface {
 	Insert(ctx context.Context, artifact types.Artifact) (types.Artifact, error)
 	ListByBotSpaceID(ctx context.Context, botSpaceID string, limit int, before *string) ([]types.Artifact, error)
+	ListByIDs(ctx context.Context, botSpaceID string, ids []string) ([]types.Artifact, error)
+	Search(ctx context.Context, botSpaceID string, query string, limit, offset int) ([]types.ArtifactMatch, error)
 	Delete(ctx context.Context, id string) error
 }
 
@@ -89,4 +120,17 @@ type BotSkillDB interface {
 	ListByBotSpaceID(ctx context.Context, botSpaceID string) ([]types.BotSkill, error)
 	Update(ctx context.Context, skill types.BotSkill) (types.BotSkill, error)
 	Delete(ctx context.Context, id string) error
+	Search(ctx context.Context, botSpaceID string, embedding pgvector.Vector, limit int) ([]types.SkillMatch, error)
+	ListUnembedded(ctx context.Context, limit int) ([]types.BotSkill, error)
+	SetEmbedding(ctx context.Context, id string, embedding pgvector.Vector) error
+}
+
+type TaskScheduleDB interface {
+	Insert(ctx context.Context, schedule types.TaskSchedule) (types.TaskSchedule, error)
+	GetByID(ctx context.Context, id string) (types.TaskSchedule, error)
+	ListByBotSpaceID(ctx context.Context, botSpaceID string) ([]types.TaskSchedule, error)
+	ListDue(ctx context.Context, now time.Time, limit int) ([]types.TaskSchedule, error)
+	SetPaused(ctx context.Context, id string, paused bool, nextRunAt time.Time) (types.TaskSchedule, error)
+	Delete(ctx context.Context, id string) error
+	WithSchedulerLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
 }
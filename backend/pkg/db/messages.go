@@ -14,9 +14,18 @@ import (
 	"github.com/numbergroup/claw-swarm/pkg/types"
 	"github.com/numbergroup/errors"
 	gocache "github.com/patrickmn/go-cache"
+	pgvector "github.com/pgvector/pgvector-go"
 	"github.com/sirupsen/logrus"
 )
 
+// searchHeadlineOptions shape the snippets of full-text search results: a few
+// short fragments around the matches, which are wrapped in **.
+const searchHeadlineOptions = `StartSel=**, StopSel=**, MaxWords=20, MinWords=5, MaxFragments=3, FragmentDelimiter=" ... "`
+
+// embedderLockKey is the advisory lock held by the replica embedding new
+// messages.
+const embedderLockKey int64 = 7_326_002
+
 type messageDB struct {
 	db                         *sqlx.DB
 	log                        logrus.Ext1FieldLogger
@@ -29,6 +38,11 @@ type messageDB struct {
 	listSpaceIDsExceedingCount *sqlx.Stmt
 	getNthNewestCreatedAt      *sqlx.Stmt
 	deleteOlderThan            *sqlx.Stmt
+	search                     *sqlx.Stmt
+	listUnembedded             *sqlx.Stmt
+	setEmbedding               *sqlx.Stmt
+	recordEmbedFailure         *sqlx.Stmt
+	recall                     *sqlx.Stmt
 	cursorTimeCache            *libcache.Cache[any]
 }
 
@@ -92,6 +106,48 @@ func NewMessageDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Messa
 		return nil, errors.Wrap(err, "failed to prepare deleteOlderThan statement")
 	}
 
+	search, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s, rank, ts_headline('english', content, query, '%s') AS snippet FROM (
+			SELECT m.*, ts_rank(m.search_vector, query) AS rank, query
+			FROM messages m, websearch_to_tsquery('english', $2) query
+			WHERE m.bot_space_id = $1 AND m.search_vector @@ query
+			ORDER BY rank DESC, m.created_at DESC LIMIT $3 OFFSET $4
+		) hits ORDER BY rank DESC, created_at DESC`, colStr, searchHeadlineOptions))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare search statement")
+	}
+
+	listUnembedded, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM messages WHERE embedded_at IS NULL AND embed_attempts < $2
+		AND (embed_failed_at IS NULL OR embed_failed_at < $3)
+		ORDER BY created_at ASC LIMIT $1`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listUnembedded statement")
+	}
+
+	setEmbedding, err := sdb.PreparexContext(ctx,
+		`UPDATE messages SET embedding = $2, embedded_at = now() WHERE id = $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare setEmbedding statement")
+	}
+
+	recordEmbedFailure, err := sdb.PreparexContext(ctx,
+		`UPDATE messages SET embed_attempts = embed_attempts + 1, embed_failed_at = now() WHERE id = $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare recordEmbedFailure statement")
+	}
+
+	// A zero embedding has a NaN distance to everything, and Postgres
+	// sorts NaN after every number, so it is filtered out rather than
+	// returned last.
+	recall, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s, embedding <=> $2 AS distance FROM messages
+		WHERE bot_space_id = $1 AND embedding IS NOT NULL AND NOT 'NaN'::float8 = (embedding <=> $2)
+		ORDER BY embedding <=> $2 LIMIT $3`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare recall statement")
+	}
+
 	gocacheClient := gocache.New(10*time.Minute, 15*time.Minute)
 	store := gocachestore.NewGoCache(gocacheClient)
 	cursorTimeCache := libcache.New[any](store)
@@ -108,6 +164,11 @@ func NewMessageDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Messa
 		listSpaceIDsExceedingCount: listSpaceIDsExceedingCount,
 		getNthNewestCreatedAt:      getNthNewestCreatedAt,
 		deleteOlderThan:            deleteOlderThan,
+		search:                     search,
+		listUnembedded:             listUnembedded,
+		setEmbedding:               setEmbedding,
+		recordEmbedFailure:         recordEmbedFailure,
+		recall:                     recall,
 		cursorTimeCache:            cursorTimeCache,
 	}, nil
 }
@@ -177,6 +238,60 @@ func (m *messageDB) ListSince(ctx context.Context, botSpaceID string, sinceID st
 	return messages, nil
 }
 
+// Search returns a page of the space's messages matching query, a web
+// search style query, best ranked first.
+func (m *messageDB) Search(ctx context.Context, botSpaceID string, query string, limit, offset int) ([]types.MessageMatch, error) {
+	matches := make([]types.MessageMatch, 0)
+	err := m.search.SelectContext(ctx, &matches, botSpaceID, query, limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search messages")
+	}
+	return matches, nil
+}
+
+// ListUnembedded returns up to limit messages of any space which have not
+// been embedded, oldest first. Messages which have failed maxAttempts times
+// are given up on, and those which last failed at or after failedBefore are
+// left for a later run.
+func (m *messageDB) ListUnembedded(ctx context.Context, limit int, maxAttempts int, failedBefore time.Time) ([]types.Message, error) {
+	messages := make([]types.Message, 0)
+	err := m.listUnembedded.SelectContext(ctx, &messages, limit, maxAttempts, failedBefore)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list unembedded messages")
+	}
+	return messages, nil
+}
+
+// SetEmbedding marks a message embedded. A nil embedding records that it
+// had nothing to embed.
+func (m *messageDB) SetEmbedding(ctx context.Context, id string, embedding *pgvector.Vector) error {
+	_, err := m.setEmbedding.ExecContext(ctx, id, embedding)
+	if err != nil {
+		return errors.Wrap(err, "failed to set message embedding")
+	}
+	return nil
+}
+
+// RecordEmbedFailure counts a failed attempt to embed a message.
+func (m *messageDB) RecordEmbedFailure(ctx context.Context, id string) error {
+	_, err := m.recordEmbedFailure.ExecContext(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to record message embed failure")
+	}
+	return nil
+}
+
+// Recall returns up to limit messages of the space nearest to embedding by
+// cosine distance, closest first. Messages not embedded yet are skipped.
+func (m *messageDB) Recall(ctx context.Context, botSpaceID string, embedding pgvector.Vector, limit int) ([]types.RecalledMessage, error) {
+	messages := make([]types.RecalledMessage, 0)
+	err := m.recall.SelectContext(ctx, &messages, botSpaceID, embedding, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to recall messages")
+	}
+	return messages, nil
+}
+
 func (m *messageDB) ListSpaceIDsExceedingCount(ctx context.Context, maxCount int) ([]string, error) {
 	var ids []string
 	err := m.listSpaceIDsExceedingCount.SelectContext(ctx, &ids, maxCount)
@@ -204,3 +319,10 @@ func (m *messageDB) DeleteOlderThanNth(ctx context.Context, botSpaceID string, k
 	}
 	return deleted, nil
 }
+
+// WithEmbedderLock runs fn while holding the message embedder's advisory lock
+// and reports whether the lock was free. Replicas which find it taken skip
+// the run rather than wait.
+func (m *messageDB) WithEmbedderLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return withAdvisoryLock(ctx, m.db, m.log, embedderLockKey, fn)
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+	"github.com/numbergroup/claw-swarm/pkg/embed"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+type messageFixture struct {
+	db         MessageDB
+	botSpaceID string
+	botID      string
+}
+
+func newMessageFixture(t *testing.T) messageFixture {
+	t.Helper()
+	conf, sdb := dbtest.New(t)
+	messageDB, err := NewMessageDB(context.Background(), conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create message db: %v", err)
+	}
+	f := messageFixture{db: messageDB, botSpaceID: dbtest.Space(t, sdb)}
+	f.botID = dbtest.Bot(t, sdb, f.botSpaceID, "bot")
+	return f
+}
+
+// message inserts a message with content, created offset after a fixed
+// time so messages list in the order the test inserts them.
+func (f messageFixture) message(t *testing.T, content string, offset time.Duration) string {
+	t.Helper()
+	id, err := f.db.Insert(context.Background(), types.Message{
+		ID:         uuid.New().String(),
+		BotSpaceID: f.botSpaceID,
+		SenderID:   f.botID,
+		SenderName: "bot",
+		SenderType: "bot",
+		Content:    content,
+		CreatedAt:  time.Now().Add(-time.Hour).Add(offset),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert message: %v", err)
+	}
+	return id
+}
+
+func vector(t *testing.T, text string) pgvector.Vector {
+	t.Helper()
+	embeddings, err := embed.NewHashEmbedder().Embed(context.Background(), []string{text})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	return pgvector.NewVector(embeddings[0])
+}
+
+// TestRecallSkipsZeroEmbeddings stores a zero embedding, as message
+// embedding once did for text without words, beside real ones. Recall must
+// leave it out rather than return a NaN distance.
+func TestRecallSkipsZeroEmbeddings(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	related := f.message(t, "deploy the api to staging", 0)
+	unrelated := f.message(t, "bake a chocolate cake", time.Second)
+	zero := f.message(t, "?!", 2*time.Second)
+	unembedded := f.message(t, "deploy the api", 3*time.Second)
+
+	for id, text := range map[string]string{related: "deploy the api to staging", unrelated: "bake a chocolate cake", zero: "?!"} {
+		vec := vector(t, text)
+		if err := f.db.SetEmbedding(ctx, id, &vec); err != nil {
+			t.Fatalf("failed to set embedding: %v", err)
+		}
+	}
+
+	recalled, err := f.db.Recall(ctx, f.botSpaceID, vector(t, "deploy the api"), 10)
+	if err != nil {
+		t.Fatalf("failed to recall messages: %v", err)
+	}
+	var got []string
+	for _, msg := range recalled {
+		got = append(got, msg.ID)
+	}
+	if len(got) != 2 || got[0] != related || got[1] != unrelated {
+		t.Errorf("got recalled %v, want %s then %s and neither %s nor %s", got, related, unrelated, zero, unembedded)
+	}
+}
+
+// TestListUnembedded lists messages still to embed, passing over those
+// embedded, those with nothing to embed, those waiting to retry and those
+// given up on.
+func TestListUnembedded(t *testing.T) {
+	f := newMessageFixture(t)
+	ctx := context.Background()
+	embedded := f.message(t, "deploy the api", 0)
+	empty := f.message(t, "?!", time.Second)
+	retrying := f.message(t, "poison", 2*time.Second)
+	exhausted := f.message(t, "poison again", 3*time.Second)
+	pending := f.message(t, "write release notes", 4*time.Second)
+
+	vec := vector(t, "deploy the api")
+	if err := f.db.SetEmbedding(ctx, embedded, &vec); err != nil {
+		t.Fatalf("failed to set embedding: %v", err)
+	}
+	if err := f.db.SetEmbedding(ctx, empty, nil); err != nil {
+		t.Fatalf("failed to set empty embedding: %v", err)
+	}
+	for _, id := range []string{retrying, exhausted, exhausted} {
+		if err := f.db.RecordEmbedFailure(ctx, id); err != nil {
+			t.Fatalf("failed to record embed failure: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		failedBefore time.Time
+		want         []string
+	}{
+		{name: "failures waiting to retry", failedBefore: time.Now().Add(-time.Hour), want: []string{pending}},
+		{name: "failures due a retry", failedBefore: time.Now().Add(time.Hour), want: []string{retrying, pending}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := f.db.ListUnembedded(ctx, 10, 2, tt.failedBefore)
+			if err != nil {
+				t.Fatalf("failed to list unembedded messages: %v", err)
+			}
+			var got []string
+			for _, msg := range messages {
+				got = append(got, msg.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -5,15 +5,35 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/innodv/psql"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/numbergroup/claw-swarm/pkg/config"
 	"github.com/numbergroup/claw-swarm/pkg/types"
 	"github.com/numbergroup/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// taskPickOrder is the order in which tasks are listed and handed out.
+const taskPickOrder = "priority DESC, due_at ASC NULLS LAST, created_at ASC"
+
+// activeTaskIndex allows each bot a single in_progress task per space.
+const activeTaskIndex = "idx_space_tasks_one_active_per_bot"
+
+var (
+	// ErrTaskConflict is returned when a task was changed since the version
+	// the caller read.
+	ErrTaskConflict = errors.New("task was modified concurrently")
+	// ErrBotBusy is returned when a write would give a bot a second task in
+	// progress.
+	ErrBotBusy = errors.New("bot already has an active task")
+	// ErrTaskNotReady is returned when a task would start before all of its
+	// dependencies are finished.
+	ErrTaskNotReady = errors.New("task dependencies are not finished")
+)
+
 type spaceTaskDB struct {
 	db               *sqlx.DB
 	log              logrus.Ext1FieldLogger
@@ -21,9 +41,22 @@ type spaceTaskDB struct {
 	insert           *sqlx.NamedStmt
 	getByID          *sqlx.Stmt
 	listByBotSpaceID *sqlx.Stmt
-	listByStatus     *sqlx.Stmt
 	getActiveByBotID *sqlx.Stmt
 	update           *sqlx.NamedStmt
+	insertDependency *sqlx.Stmt
+	lockDependencies *sqlx.Stmt
+	listDependencies *sqlx.Stmt
+	lockDependents   *sqlx.Stmt
+	unlockDependents *sqlx.Stmt
+	listProgress     *sqlx.Stmt
+	listSubtree      *sqlx.Stmt
+	claimNext        *sqlx.Stmt
+	renewLease       *sqlx.Stmt
+	releaseExpired   *sqlx.Stmt
+	insertEvent      *sqlx.NamedStmt
+	listEvents       *sqlx.Stmt
+	advanceSchedule  *sqlx.Stmt
+	listBotLoads     *sqlx.Stmt
 }
 
 func NewSpaceTaskDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (SpaceTaskDB, error) {
@@ -45,17 +78,15 @@ func NewSpaceTaskDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Spa
 	}
 
 	listByBotSpaceID, err := sdb.PreparexContext(ctx, fmt.Sprintf(
-		`SELECT %s FROM space_tasks WHERE bot_space_id = $1 ORDER BY created_at ASC`, colStr))
+		`SELECT %s FROM space_tasks WHERE bot_space_id = $1
+		AND ($2::text IS NULL OR status = $2)
+		AND ($3::uuid IS NULL OR bot_id = $3)
+		AND ($4::uuid IS NULL OR parent_task_id = $4)
+		ORDER BY %s`, colStr, taskPickOrder))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to prepare listByBotSpaceID statement")
 	}
 
-	listByStatus, err := sdb.PreparexContext(ctx, fmt.Sprintf(
-		`SELECT %s FROM space_tasks WHERE bot_space_id = $1 AND status = $2 ORDER BY created_at ASC`, colStr))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to prepare listByStatus statement")
-	}
-
 	getActiveByBotID, err := sdb.PreparexContext(ctx, fmt.Sprintf(
 		`SELECT %s FROM space_tasks WHERE bot_space_id = $1 AND bot_id = $2 AND status = 'in_progress' LIMIT 1`, colStr))
 	if err != nil {
@@ -64,11 +95,172 @@ func NewSpaceTaskDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Spa
 
 	update, err := sdb.PrepareNamedContext(ctx, fmt.Sprintf(
 		`UPDATE space_tasks SET status = :status, bot_id = :bot_id, completed_at = :completed_at,
-		updated_at = :updated_at WHERE id = :id RETURNING %s`, colStr))
+		lease_expires_at = :lease_expires_at, updated_at = :updated_at, version = version + 1
+		WHERE id = :id AND version = :version RETURNING %s`, colStr))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to prepare update statement")
 	}
 
+	insertDependency, err := sdb.PreparexContext(ctx,
+		`INSERT INTO space_task_dependencies (task_id, depends_on_task_id) VALUES ($1, $2)`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare insertDependency statement")
+	}
+
+	// Share locks hold off a dependency's transition until the dependent task
+	// and its edges are committed, so unlockDependents is sure to see them.
+	lockDependencies, err := sdb.PreparexContext(ctx,
+		`SELECT status FROM space_tasks WHERE id = ANY($1) FOR SHARE`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare lockDependencies statement")
+	}
+
+	listDependencies, err := sdb.PreparexContext(ctx,
+		`SELECT task_id, depends_on_task_id FROM space_task_dependencies WHERE task_id = ANY($1)`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listDependencies statement")
+	}
+
+	// Locking the waiting dependents first serializes transactions finishing
+	// two of their dependencies at once. The later one then reads the
+	// earlier one's outcome when it unlocks, rather than both missing it.
+	lockDependents, err := sdb.PreparexContext(ctx,
+		`SELECT id FROM space_tasks WHERE status = 'waiting'
+		AND id IN (SELECT task_id FROM space_task_dependencies WHERE depends_on_task_id = $1)
+		ORDER BY id FOR UPDATE`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare lockDependents statement")
+	}
+
+	unlockDependents, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH unlocked AS (
+			UPDATE space_tasks t SET status = 'available', updated_at = now(), version = version + 1
+			WHERE t.status = 'waiting'
+			AND t.id IN (SELECT task_id FROM space_task_dependencies WHERE depends_on_task_id = $1)
+			AND NOT EXISTS (
+				SELECT 1 FROM space_task_dependencies d JOIN space_tasks dep ON dep.id = d.depends_on_task_id
+				WHERE d.task_id = t.id AND dep.status NOT IN ('completed', 'cancelled')
+			)
+			RETURNING %s
+		), logged AS (
+			INSERT INTO task_events (task_id, bot_space_id, type, actor_type, from_status, to_status, note)
+			SELECT u.id, u.bot_space_id, 'unlocked', 'system', 'waiting', u.status,
+				CASE WHEN EXISTS (
+					SELECT 1 FROM space_task_dependencies d JOIN space_tasks dep ON dep.id = d.depends_on_task_id
+					WHERE d.task_id = u.id AND dep.status = 'cancelled'
+				) THEN 'all dependencies finished; some were cancelled' ELSE 'all dependencies completed' END
+			FROM unlocked u
+		)
+		SELECT %s FROM unlocked`, prefixColumns("t", rawCols), colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare unlockDependents statement")
+	}
+
+	listProgress, err := sdb.PreparexContext(ctx,
+		`SELECT parent_task_id, count(*) FILTER (WHERE status = 'completed') AS completed, count(*) AS total
+		FROM space_tasks WHERE parent_task_id = ANY($1) AND status NOT IN ('proposed', 'rejected', 'cancelled')
+		GROUP BY parent_task_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listProgress statement")
+	}
+
+	listSubtree, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH RECURSIVE subtree AS (
+			SELECT * FROM space_tasks WHERE id = $1
+			UNION ALL
+			SELECT t.* FROM space_tasks t JOIN subtree s ON t.parent_task_id = s.id
+		)
+		SELECT %s FROM subtree ORDER BY created_at ASC`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listSubtree statement")
+	}
+
+	claimNext, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH claimed AS (
+			UPDATE space_tasks SET status = 'in_progress', bot_id = $2, lease_expires_at = $3,
+			updated_at = now(), version = version + 1
+			WHERE status = 'available' AND id = (
+				SELECT t.id FROM space_tasks t WHERE t.bot_space_id = $1 AND t.status = 'available'
+				AND (coalesce(cardinality(t.required_skills), 0) = 0 OR EXISTS (
+					SELECT 1 FROM bot_skills s
+					CROSS JOIN LATERAL unnest(array_append(coalesce(s.tags, '{}'), s.name)) AS has(skill)
+					WHERE s.bot_id = $2 AND lower(trim(has.skill)) = ANY (t.required_skills)
+				))
+				ORDER BY %s LIMIT 1 FOR UPDATE OF t SKIP LOCKED
+			)
+			RETURNING %s
+		), logged AS (
+			INSERT INTO task_events (task_id, bot_space_id, type, actor_type, actor_id, from_status, to_status, note)
+			SELECT id, bot_space_id, 'accepted', 'bot', bot_id, 'available', status, 'claimed as the next task'
+			FROM claimed
+		)
+		SELECT %s FROM claimed`, taskPickOrder, colStr, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare claimNext statement")
+	}
+
+	renewLease, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`UPDATE space_tasks SET lease_expires_at = $3
+		WHERE id = $1 AND bot_id = $2 AND status = 'in_progress'
+		RETURNING %s`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare renewLease statement")
+	}
+
+	releaseExpired, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`WITH released AS (
+			UPDATE space_tasks t SET status = 'available', bot_id = NULL, lease_expires_at = NULL,
+			updated_at = now(), version = t.version + 1
+			FROM (
+				SELECT id, bot_id FROM space_tasks
+				WHERE status = 'in_progress' AND lease_expires_at < now()
+				LIMIT $1 FOR UPDATE SKIP LOCKED
+			) expired
+			WHERE t.id = expired.id
+			RETURNING %s, expired.bot_id AS released_bot_id
+		), logged AS (
+			INSERT INTO task_events (task_id, bot_space_id, type, actor_type, from_status, to_status, note)
+			SELECT id, bot_space_id, 'released', 'system', 'in_progress', status, 'lease expired'
+			FROM released
+		)
+		SELECT %s, released_bot_id FROM released`, prefixColumns("t", rawCols), colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare releaseExpired statement")
+	}
+
+	eventCols := psql.GetSQLColumnsQuoted[types.TaskEvent]()
+	eventColStr := strings.Join(eventCols, ", ")
+	rawEventCols := psql.GetSQLColumns[types.TaskEvent]()
+
+	insertEvent, err := sdb.PrepareNamedContext(ctx, fmt.Sprintf(
+		`INSERT INTO task_events (%s) VALUES (:%s)`,
+		eventColStr, strings.Join(rawEventCols, ", :")))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare insertEvent statement")
+	}
+
+	listEvents, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM task_events WHERE task_id = $1 ORDER BY seq ASC`, eventColStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listEvents statement")
+	}
+
+	advanceSchedule, err := sdb.PreparexContext(ctx,
+		`UPDATE task_schedules SET next_run_at = $3, last_run_at = $2, updated_at = now()
+		WHERE id = $1 AND next_run_at = $2 AND NOT paused`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare advanceSchedule statement")
+	}
+
+	listBotLoads, err := sdb.PreparexContext(ctx,
+		`SELECT bot_id, count(*) FILTER (WHERE status = 'in_progress') AS active, count(*) AS open
+		FROM space_tasks WHERE bot_space_id = $1 AND bot_id IS NOT NULL
+		AND status IN ('in_progress', 'in_review', 'blocked')
+		GROUP BY bot_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listBotLoads statement")
+	}
+
 	return &spaceTaskDB{
 		db:               sdb,
 		log:              conf.GetLogger(),
@@ -76,18 +268,136 @@ func NewSpaceTaskDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Spa
 		insert:           insert,
 		getByID:          getByID,
 		listByBotSpaceID: listByBotSpaceID,
-		listByStatus:     listByStatus,
 		getActiveByBotID: getActiveByBotID,
 		update:           update,
+		insertDependency: insertDependency,
+		lockDependencies: lockDependencies,
+		listDependencies: listDependencies,
+		lockDependents:   lockDependents,
+		unlockDependents: unlockDependents,
+		listProgress:     listProgress,
+		listSubtree:      listSubtree,
+		claimNext:        claimNext,
+		renewLease:       renewLease,
+		releaseExpired:   releaseExpired,
+		insertEvent:      insertEvent,
+		listEvents:       listEvents,
+		advanceSchedule:  advanceSchedule,
+		listBotLoads:     listBotLoads,
 	}, nil
 }
 
-func (s *spaceTaskDB) Insert(ctx context.Context, task types.SpaceTask) (types.SpaceTask, error) {
+func prefixColumns(alias string, cols []string) string {
+	prefixed := make([]string, len(cols))
+	for i, col := range cols {
+		prefixed[i] = fmt.Sprintf(`%s."%s"`, alias, col)
+	}
+	return strings.Join(prefixed, ", ")
+}
+
+// withDetails fills in DependsOn and Progress for tasks.
+func (s *spaceTaskDB) withDetails(ctx context.Context, tasks []types.SpaceTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	ids := make([]string, len(tasks))
+	byID := make(map[string]*types.SpaceTask, len(tasks))
+	for i := range tasks {
+		tasks[i].DependsOn = []string{}
+		ids[i] = tasks[i].ID
+		byID[tasks[i].ID] = &tasks[i]
+	}
+
+	var deps []struct {
+		TaskID          string `db:"task_id"`
+		DependsOnTaskID string `db:"depends_on_task_id"`
+	}
+	err := s.listDependencies.SelectContext(ctx, &deps, pq.Array(ids))
+	if err != nil {
+		return errors.Wrap(err, "failed to list task dependencies")
+	}
+	for _, dep := range deps {
+		task := byID[dep.TaskID]
+		task.DependsOn = append(task.DependsOn, dep.DependsOnTaskID)
+	}
+
+	var progress []struct {
+		ParentTaskID string `db:"parent_task_id"`
+		types.TaskProgress
+	}
+	err = s.listProgress.SelectContext(ctx, &progress, pq.Array(ids))
+	if err != nil {
+		return errors.Wrap(err, "failed to list subtask progress")
+	}
+	for _, p := range progress {
+		rollup := p.TaskProgress
+		byID[p.ParentTaskID].Progress = &rollup
+	}
+	return nil
+}
+
+// Insert stores task together with its DependsOn edges. A task with
+// dependencies starts out waiting unless they are all completed or
+// cancelled, which is decided under lock so a dependency finishing at the
+// same time cannot leave it waiting forever. Asking for an in_progress task
+// whose dependencies are still open returns ErrTaskNotReady.
+//
+// Dependencies are only ever recorded here, for a task nobody can depend on
+// yet, so the dependency graph cannot have cycles.
+func (s *spaceTaskDB) Insert(ctx context.Context, task types.SpaceTask, event types.TaskEvent) (types.SpaceTask, error) {
 	var result types.SpaceTask
-	err := s.insert.GetContext(ctx, &result, task)
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
+		return result, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if len(task.DependsOn) > 0 {
+		var statuses []string
+		err := tx.StmtxContext(ctx, s.lockDependencies).SelectContext(ctx, &statuses, pq.Array(task.DependsOn))
+		if err != nil {
+			return result, errors.Wrap(err, "failed to lock task dependencies")
+		}
+		ready := true
+		for _, status := range statuses {
+			if status != types.TaskStatusCompleted && status != types.TaskStatusCancelled {
+				ready = false
+			}
+		}
+		switch {
+		case !ready && task.Status == types.TaskStatusInProgress:
+			return result, ErrTaskNotReady
+		case !ready && task.Status == types.TaskStatusAvailable:
+			task.Status = types.TaskStatusWaiting
+		case ready && task.Status == types.TaskStatusWaiting:
+			task.Status = types.TaskStatusAvailable
+		}
+		event.ToStatus = task.Status
+	}
+
+	err = tx.NamedStmtContext(ctx, s.insert).GetContext(ctx, &result, task)
+	if err != nil {
+		if isActiveTaskViolation(err) {
+			return result, ErrBotBusy
+		}
 		return result, errors.Wrap(err, "failed to insert space task")
 	}
+
+	insertDependency := tx.StmtxContext(ctx, s.insertDependency)
+	for _, dep := range task.DependsOn {
+		if _, err := insertDependency.ExecContext(ctx, result.ID, dep); err != nil {
+			return result, errors.Wrap(err, "failed to insert task dependency")
+		}
+	}
+
+	if _, err := tx.NamedStmtContext(ctx, s.insertEvent).ExecContext(ctx, event); err != nil {
+		return result, errors.Wrap(err, "failed to insert task event")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, errors.Wrap(err, "failed to commit space task")
+	}
+	result.DependsOn = append([]string{}, task.DependsOn...)
 	return result, nil
 }
 
@@ -97,20 +407,22 @@ func (s *spaceTaskDB) GetByID(ctx context.Context, id string) (types.SpaceTask,
 	if err != nil {
 		return task, errors.Wrap(err, "failed to get space task")
 	}
-	return task, nil
+	tasks := []types.SpaceTask{task}
+	if err := s.withDetails(ctx, tasks); err != nil {
+		return task, err
+	}
+	return tasks[0], nil
 }
 
-func (s *spaceTaskDB) ListByBotSpaceID(ctx context.Context, botSpaceID string, status *string) ([]types.SpaceTask, error) {
+func (s *spaceTaskDB) ListByBotSpaceID(ctx context.Context, botSpaceID string, filter types.TaskFilter) ([]types.SpaceTask, error) {
 	tasks := make([]types.SpaceTask, 0)
-	var err error
-	if status != nil {
-		err = s.listByStatus.SelectContext(ctx, &tasks, botSpaceID, *status)
-	} else {
-		err = s.listByBotSpaceID.SelectContext(ctx, &tasks, botSpaceID)
-	}
+	err := s.listByBotSpaceID.SelectContext(ctx, &tasks, botSpaceID, filter.Status, filter.BotID, filter.ParentTaskID)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to list space tasks")
 	}
+	if err := s.withDetails(ctx, tasks); err != nil {
+		return nil, err
+	}
 	return tasks, nil
 }
 
@@ -123,14 +435,215 @@ func (s *spaceTaskDB) GetActiveByBotID(ctx context.Context, botSpaceID string, b
 		}
 		return nil, errors.Wrap(err, "failed to get active task for bot")
 	}
-	return &task, nil
+	tasks := []types.SpaceTask{task}
+	if err := s.withDetails(ctx, tasks); err != nil {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+// Update writes task only if its stored version still equals task.Version,
+// and bumps the version. It returns ErrTaskConflict if the task changed in
+// the meantime. event is appended to the task's audit log in the same
+// transaction, so no transition goes unrecorded.
+func (s *spaceTaskDB) Update(ctx context.Context, task types.SpaceTask, event types.TaskEvent) (types.SpaceTask, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return types.SpaceTask{}, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	result, err := s.updateTx(ctx, tx, task, event)
+	if err != nil {
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, errors.Wrap(err, "failed to commit space task")
+	}
+	return result, nil
 }
 
-func (s *spaceTaskDB) Update(ctx context.Context, task types.SpaceTask) (types.SpaceTask, error) {
+// Finish is Update for a task becoming completed or cancelled. In the same
+// transaction it makes available every waiting task whose dependencies are
+// now all finished, and returns those too. Their unlocked event notes when a
+// dependency was cancelled rather than completed.
+func (s *spaceTaskDB) Finish(ctx context.Context, task types.SpaceTask, event types.TaskEvent) (types.SpaceTask, []types.SpaceTask, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return types.SpaceTask{}, nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	result, err := s.updateTx(ctx, tx, task, event)
+	if err != nil {
+		return result, nil, err
+	}
+
+	var waiting []string
+	err = tx.StmtxContext(ctx, s.lockDependents).SelectContext(ctx, &waiting, result.ID)
+	if err != nil {
+		return result, nil, errors.Wrap(err, "failed to lock dependent tasks")
+	}
+
+	unlocked := make([]types.SpaceTask, 0)
+	if len(waiting) > 0 {
+		err = tx.StmtxContext(ctx, s.unlockDependents).SelectContext(ctx, &unlocked, result.ID)
+		if err != nil {
+			return result, nil, errors.Wrap(err, "failed to unlock dependent tasks")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, nil, errors.Wrap(err, "failed to commit space task")
+	}
+	if err := s.withDetails(ctx, unlocked); err != nil {
+		return result, nil, err
+	}
+	return result, unlocked, nil
+}
+
+func (s *spaceTaskDB) updateTx(ctx context.Context, tx *sqlx.Tx, task types.SpaceTask, event types.TaskEvent) (types.SpaceTask, error) {
 	var result types.SpaceTask
-	err := s.update.GetContext(ctx, &result, task)
+	err := tx.NamedStmtContext(ctx, s.update).GetContext(ctx, &result, task)
 	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return result, ErrTaskConflict
+		}
+		if isActiveTaskViolation(err) {
+			return result, ErrBotBusy
+		}
 		return result, errors.Wrap(err, "failed to update space task")
 	}
+
+	if _, err := tx.NamedStmtContext(ctx, s.insertEvent).ExecContext(ctx, event); err != nil {
+		return result, errors.Wrap(err, "failed to insert task event")
+	}
+	result.DependsOn = task.DependsOn
+	result.Progress = task.Progress
 	return result, nil
 }
+
+func isActiveTaskViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "23505" && pqErr.Constraint == activeTaskIndex
+}
+
+// ListSubtree returns the task rootID followed by all of its descendants.
+func (s *spaceTaskDB) ListSubtree(ctx context.Context, rootID string) ([]types.SpaceTask, error) {
+	tasks := make([]types.SpaceTask, 0)
+	err := s.listSubtree.SelectContext(ctx, &tasks, rootID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list task subtree")
+	}
+	if err := s.withDetails(ctx, tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// ClaimNext assigns the highest priority available task in the space to
+// botID and returns it, or nil if there is none. Tasks requiring skills are
+// only claimed by bots with a skill name or tag matching one of them, as
+// routing would suggest. Concurrent callers never claim the same task.
+func (s *spaceTaskDB) ClaimNext(ctx context.Context, botSpaceID string, botID string, leaseExpiresAt time.Time) (*types.SpaceTask, error) {
+	var task types.SpaceTask
+	err := s.claimNext.GetContext(ctx, &task, botSpaceID, botID, leaseExpiresAt)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, nil
+		}
+		if isActiveTaskViolation(err) {
+			return nil, ErrBotBusy
+		}
+		return nil, errors.Wrap(err, "failed to claim next task")
+	}
+	tasks := []types.SpaceTask{task}
+	if err := s.withDetails(ctx, tasks); err != nil {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+// RenewLease extends the lease botID holds on taskID. It returns
+// ErrTaskConflict if the task is no longer in progress with that bot.
+func (s *spaceTaskDB) RenewLease(ctx context.Context, taskID string, botID string, leaseExpiresAt time.Time) (types.SpaceTask, error) {
+	var task types.SpaceTask
+	err := s.renewLease.GetContext(ctx, &task, taskID, botID, leaseExpiresAt)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return task, ErrTaskConflict
+		}
+		return task, errors.Wrap(err, "failed to renew task lease")
+	}
+	return task, nil
+}
+
+// ReleaseExpired returns up to limit tasks whose lease has lapsed to
+// available. Each task is released by exactly one caller.
+func (s *spaceTaskDB) ReleaseExpired(ctx context.Context, limit int) ([]types.ReleasedTask, error) {
+	released := make([]types.ReleasedTask, 0)
+	err := s.releaseExpired.SelectContext(ctx, &released, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to release expired tasks")
+	}
+	return released, nil
+}
+
+// ListEvents returns the events recorded for taskID in the order they were
+// written, whichever clock stamped their created_at.
+func (s *spaceTaskDB) ListEvents(ctx context.Context, taskID string) ([]types.TaskEvent, error) {
+	events := make([]types.TaskEvent, 0)
+	err := s.listEvents.SelectContext(ctx, &events, taskID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list task events")
+	}
+	return events, nil
+}
+
+// InsertFromSchedule inserts task for the due run of schedule and moves the
+// schedule on to nextRunAt in the same transaction. It reports false, and
+// inserts nothing, if the run was already taken or the schedule was paused
+// or changed in the meantime.
+func (s *spaceTaskDB) InsertFromSchedule(ctx context.Context, task types.SpaceTask, event types.TaskEvent, schedule types.TaskSchedule, nextRunAt time.Time) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	advanced, err := tx.StmtxContext(ctx, s.advanceSchedule).ExecContext(ctx, schedule.ID, schedule.NextRunAt, nextRunAt)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to advance task schedule")
+	}
+	affected, err := advanced.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get rows affected")
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.NamedStmtContext(ctx, s.insert).ExecContext(ctx, task); err != nil {
+		return false, errors.Wrap(err, "failed to insert scheduled task")
+	}
+	if _, err := tx.NamedStmtContext(ctx, s.insertEvent).ExecContext(ctx, event); err != nil {
+		return false, errors.Wrap(err, "failed to insert task event")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "failed to commit scheduled task")
+	}
+	return true, nil
+}
+
+// ListBotLoads returns the unfinished task counts of every bot in the space
+// holding at least one such task.
+func (s *spaceTaskDB) ListBotLoads(ctx context.Context, botSpaceID string) ([]types.BotTaskLoad, error) {
+	loads := make([]types.BotTaskLoad, 0)
+	err := s.listBotLoads.SelectContext(ctx, &loads, botSpaceID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list bot task loads")
+	}
+	return loads, nil
+}
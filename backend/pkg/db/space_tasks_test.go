@@ -0,0 +1,461 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+)
+
+const racers = 8
+
+type taskFixture struct {
+	sdb        *sqlx.DB
+	db         SpaceTaskDB
+	botSpaceID string
+	botIDs     []string
+}
+
+func newTaskFixture(t *testing.T) taskFixture {
+	t.Helper()
+	conf, sdb := dbtest.New(t)
+	// Every racer needs its own connection for the race to be real.
+	sdb.SetMaxOpenConns(racers + 1)
+
+	taskDB, err := NewSpaceTaskDB(context.Background(), conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create space task db: %v", err)
+	}
+	f := taskFixture{sdb: sdb, db: taskDB, botSpaceID: dbtest.Space(t, sdb)}
+	for range racers {
+		f.botIDs = append(f.botIDs, dbtest.Bot(t, sdb, f.botSpaceID, "bot"))
+	}
+	return f
+}
+
+// insertTasks inserts n available tasks.
+func (f taskFixture) insertTasks(t *testing.T, n int) []types.SpaceTask {
+	t.Helper()
+	tasks := make([]types.SpaceTask, n)
+	for i := range tasks {
+		now := time.Now()
+		task := types.SpaceTask{
+			ID:             uuid.New().String(),
+			BotSpaceID:     f.botSpaceID,
+			Name:           "task",
+			Status:         types.TaskStatusAvailable,
+			CreatedByType:  types.ActorBot,
+			CreatedByID:    f.botIDs[0],
+			Version:        1,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+			RequiredSkills: pq.StringArray{},
+			DependsOn:      []string{},
+		}
+		inserted, err := f.db.Insert(context.Background(), task, taskEvent(task, types.TaskEventCreated))
+		if err != nil {
+			t.Fatalf("failed to insert task: %v", err)
+		}
+		tasks[i] = inserted
+	}
+	return tasks
+}
+
+func taskEvent(task types.SpaceTask, eventType string) types.TaskEvent {
+	return types.TaskEvent{
+		ID:          uuid.New().String(),
+		TaskID:      task.ID,
+		BotSpaceID:  task.BotSpaceID,
+		Type:        eventType,
+		ActorType:   types.ActorBot,
+		ToStatus:    task.Status,
+		ArtifactIDs: pq.StringArray{},
+		CreatedAt:   time.Now(),
+	}
+}
+
+// accepted returns task moved to in_progress with botID, as accepting or
+// assigning it does.
+func accepted(task types.SpaceTask, botID string) types.SpaceTask {
+	task.Status = types.TaskStatusInProgress
+	task.BotID = &botID
+	task.UpdatedAt = time.Now()
+	return task
+}
+
+// race runs fn once per racer at the same moment and returns their errors.
+func race(fn func(i int) error) []error {
+	errs := make([]error, racers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = fn(i)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// expectOneWinner fails unless exactly one error is nil and every other one
+// is want.
+func expectOneWinner(t *testing.T, errs []error, want error) {
+	t.Helper()
+	wins := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			wins++
+		case want:
+		default:
+			t.Errorf("got error %v, want nil or %v", err, want)
+		}
+	}
+	if wins != 1 {
+		t.Errorf("got %d successful writes, want 1", wins)
+	}
+}
+
+// TestUpdateAcceptRace has every bot accept the same task at once.
+func TestUpdateAcceptRace(t *testing.T) {
+	f := newTaskFixture(t)
+	task := f.insertTasks(t, 1)[0]
+
+	errs := race(func(i int) error {
+		next := accepted(task, f.botIDs[i])
+		_, err := f.db.Update(context.Background(), next, taskEvent(next, types.TaskEventAccepted))
+		return err
+	})
+	expectOneWinner(t, errs, ErrTaskConflict)
+}
+
+// TestUpdateAssignRace assigns a different task to the same bot from every
+// racer at once.
+func TestUpdateAssignRace(t *testing.T) {
+	f := newTaskFixture(t)
+	tasks := f.insertTasks(t, racers)
+	botID := f.botIDs[0]
+
+	errs := race(func(i int) error {
+		next := accepted(tasks[i], botID)
+		_, err := f.db.Update(context.Background(), next, taskEvent(next, types.TaskEventAssigned))
+		return err
+	})
+	expectOneWinner(t, errs, ErrBotBusy)
+}
+
+// TestClaimNextRace has every bot claim at once; each must get a task of its
+// own.
+func TestClaimNextRace(t *testing.T) {
+	f := newTaskFixture(t)
+	f.insertTasks(t, racers)
+	lease := time.Now().Add(time.Minute)
+
+	claimed := make([]*types.SpaceTask, racers)
+	errs := race(func(i int) error {
+		var err error
+		claimed[i], err = f.db.ClaimNext(context.Background(), f.botSpaceID, f.botIDs[i], lease)
+		return err
+	})
+
+	seen := make(map[string]bool)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("claim failed: %v", err)
+		}
+		if claimed[i] == nil {
+			continue
+		}
+		if seen[claimed[i].ID] {
+			t.Errorf("task %s claimed twice", claimed[i].ID)
+		}
+		seen[claimed[i].ID] = true
+	}
+	if len(seen) == 0 {
+		t.Error("no task was claimed")
+	}
+}
+
+// TestClaimNextSameBotRace has one bot claim from every racer at once.
+func TestClaimNextSameBotRace(t *testing.T) {
+	f := newTaskFixture(t)
+	f.insertTasks(t, racers)
+	lease := time.Now().Add(time.Minute)
+	botID := f.botIDs[0]
+
+	claimed := make([]*types.SpaceTask, racers)
+	errs := race(func(i int) error {
+		var err error
+		claimed[i], err = f.db.ClaimNext(context.Background(), f.botSpaceID, botID, lease)
+		return err
+	})
+	expectOneWinner(t, errs, ErrBotBusy)
+
+	for i, err := range errs {
+		if err == nil && claimed[i] == nil {
+			t.Error("the successful claim returned no task")
+		}
+	}
+}
+
+// dependent returns a new task waiting on deps.
+func (f taskFixture) dependent(deps ...types.SpaceTask) types.SpaceTask {
+	now := time.Now()
+	task := types.SpaceTask{
+		ID:             uuid.New().String(),
+		BotSpaceID:     f.botSpaceID,
+		Name:           "dependent",
+		Status:         types.TaskStatusAvailable,
+		CreatedByType:  types.ActorBot,
+		CreatedByID:    f.botIDs[0],
+		Version:        1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		RequiredSkills: pq.StringArray{},
+		DependsOn:      []string{},
+	}
+	for _, dep := range deps {
+		task.DependsOn = append(task.DependsOn, dep.ID)
+	}
+	return task
+}
+
+// finished returns task moved to status, as completing or cancelling it does.
+func finished(task types.SpaceTask, status string) types.SpaceTask {
+	task.Status = status
+	task.UpdatedAt = time.Now()
+	return task
+}
+
+// TestFinishUnlocksAfterCancel cancels one of two dependencies, then
+// completes the other; the dependent must unlock only once both finish.
+func TestFinishUnlocksAfterCancel(t *testing.T) {
+	f := newTaskFixture(t)
+	ctx := context.Background()
+	deps := f.insertTasks(t, 2)
+
+	dependent := f.dependent(deps...)
+	inserted, err := f.db.Insert(ctx, dependent, taskEvent(dependent, types.TaskEventCreated))
+	if err != nil {
+		t.Fatalf("failed to insert dependent task: %v", err)
+	}
+	if inserted.Status != types.TaskStatusWaiting {
+		t.Fatalf("got dependent status %q, want %q", inserted.Status, types.TaskStatusWaiting)
+	}
+
+	finish := func(task types.SpaceTask, status, eventType string) []types.SpaceTask {
+		t.Helper()
+		next := finished(task, status)
+		_, unlocked, err := f.db.Finish(ctx, next, taskEvent(next, eventType))
+		if err != nil {
+			t.Fatalf("failed to finish task: %v", err)
+		}
+		return unlocked
+	}
+
+	if unlocked := finish(deps[0], types.TaskStatusCancelled, types.TaskEventCancelled); len(unlocked) != 0 {
+		t.Fatalf("got %d unlocked tasks with a dependency still open, want 0", len(unlocked))
+	}
+	unlocked := finish(deps[1], types.TaskStatusCompleted, types.TaskEventCompleted)
+	if len(unlocked) != 1 || unlocked[0].ID != dependent.ID || unlocked[0].Status != types.TaskStatusAvailable {
+		t.Fatalf("got unlocked tasks %+v, want only the dependent, available", unlocked)
+	}
+
+	events, err := f.db.ListEvents(ctx, dependent.ID)
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	last := events[len(events)-1]
+	if last.Type != types.TaskEventUnlocked || last.Note == nil || !strings.Contains(*last.Note, "cancelled") {
+		t.Fatalf("got last event %q with note %v, want an unlocked event noting the cancellation", last.Type, last.Note)
+	}
+}
+
+// TestInsertDecidesReadiness checks the initial status Insert settles on for
+// a task with dependencies.
+func TestInsertDecidesReadiness(t *testing.T) {
+	f := newTaskFixture(t)
+	ctx := context.Background()
+	deps := f.insertTasks(t, 2)
+	next := finished(deps[0], types.TaskStatusCompleted)
+	if _, _, err := f.db.Finish(ctx, next, taskEvent(next, types.TaskEventCompleted)); err != nil {
+		t.Fatalf("failed to complete task: %v", err)
+	}
+
+	// Asked to wait on finished dependencies, it starts available.
+	ready := f.dependent(deps[0])
+	ready.Status = types.TaskStatusWaiting
+	inserted, err := f.db.Insert(ctx, ready, taskEvent(ready, types.TaskEventCreated))
+	if err != nil {
+		t.Fatalf("failed to insert task: %v", err)
+	}
+	if inserted.Status != types.TaskStatusAvailable {
+		t.Errorf("got status %q with finished dependencies, want %q", inserted.Status, types.TaskStatusAvailable)
+	}
+
+	// Assigned with an open dependency, it is refused.
+	assigned := accepted(f.dependent(deps...), f.botIDs[0])
+	if _, err := f.db.Insert(ctx, assigned, taskEvent(assigned, types.TaskEventCreated)); err != ErrTaskNotReady {
+		t.Errorf("got error %v inserting an assigned task with an open dependency, want %v", err, ErrTaskNotReady)
+	}
+}
+
+// TestFinishDependenciesRace completes every dependency of a task at once.
+// Exactly one of them must unlock it.
+func TestFinishDependenciesRace(t *testing.T) {
+	f := newTaskFixture(t)
+	ctx := context.Background()
+	deps := f.insertTasks(t, racers)
+	dependent := f.dependent(deps...)
+	if _, err := f.db.Insert(ctx, dependent, taskEvent(dependent, types.TaskEventCreated)); err != nil {
+		t.Fatalf("failed to insert dependent task: %v", err)
+	}
+
+	unlocked := make([][]types.SpaceTask, racers)
+	errs := race(func(i int) error {
+		next := finished(deps[i], types.TaskStatusCompleted)
+		var err error
+		_, unlocked[i], err = f.db.Finish(ctx, next, taskEvent(next, types.TaskEventCompleted))
+		return err
+	})
+
+	unlocks := 0
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("failed to complete task: %v", err)
+		}
+		unlocks += len(unlocked[i])
+	}
+	if unlocks != 1 {
+		t.Errorf("dependent was unlocked %d times, want 1", unlocks)
+	}
+	got, err := f.db.GetByID(ctx, dependent.ID)
+	if err != nil {
+		t.Fatalf("failed to get dependent task: %v", err)
+	}
+	if got.Status != types.TaskStatusAvailable {
+		t.Errorf("got dependent status %q, want %q", got.Status, types.TaskStatusAvailable)
+	}
+}
+
+// TestInsertWhileDependencyFinishes creates dependents of a task while it
+// completes. None may be left waiting on a finished task.
+func TestInsertWhileDependencyFinishes(t *testing.T) {
+	f := newTaskFixture(t)
+	ctx := context.Background()
+	dep := f.insertTasks(t, 1)[0]
+
+	dependents := make([]types.SpaceTask, racers)
+	for i := range dependents {
+		dependents[i] = f.dependent(dep)
+	}
+	errs := race(func(i int) error {
+		if i == 0 {
+			next := finished(dep, types.TaskStatusCompleted)
+			_, _, err := f.db.Finish(ctx, next, taskEvent(next, types.TaskEventCompleted))
+			return err
+		}
+		_, err := f.db.Insert(ctx, dependents[i], taskEvent(dependents[i], types.TaskEventCreated))
+		return err
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("racer %d failed: %v", i, err)
+		}
+		if i == 0 {
+			continue
+		}
+		got, err := f.db.GetByID(ctx, dependents[i].ID)
+		if err != nil {
+			t.Fatalf("failed to get dependent task: %v", err)
+		}
+		if got.Status != types.TaskStatusAvailable {
+			t.Errorf("dependent %d has status %q after its dependency completed, want %q", i, got.Status, types.TaskStatusAvailable)
+		}
+	}
+}
+
+// TestListEventsOrder records a task's creation with an application clock
+// running ahead of the database, then has the database record its claim.
+// History must still list the creation first.
+func TestListEventsOrder(t *testing.T) {
+	f := newTaskFixture(t)
+	ctx := context.Background()
+	task := f.dependent()
+	created := taskEvent(task, types.TaskEventCreated)
+	created.CreatedAt = time.Now().Add(time.Hour)
+	if _, err := f.db.Insert(ctx, task, created); err != nil {
+		t.Fatalf("failed to insert task: %v", err)
+	}
+	if _, err := f.db.ClaimNext(ctx, f.botSpaceID, f.botIDs[0], time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("failed to claim task: %v", err)
+	}
+
+	events, err := f.db.ListEvents(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Type)
+	}
+	if want := []string{types.TaskEventCreated, types.TaskEventAccepted}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+// TestClaimNextRequiredSkills offers a high priority task requiring a skill
+// and a low priority one requiring none. Only a bot with the skill, here as
+// a tag, may claim the first.
+func TestClaimNextRequiredSkills(t *testing.T) {
+	f := newTaskFixture(t)
+	ctx := context.Background()
+	skilled := f.dependent()
+	skilled.Priority = 10
+	skilled.RequiredSkills = pq.StringArray{"go", "rust"}
+	plain := f.dependent()
+	for _, task := range []types.SpaceTask{skilled, plain} {
+		if _, err := f.db.Insert(ctx, task, taskEvent(task, types.TaskEventCreated)); err != nil {
+			t.Fatalf("failed to insert task: %v", err)
+		}
+	}
+	_, err := f.sdb.ExecContext(ctx, `INSERT INTO bot_skills (bot_space_id, bot_id, bot_name, name, description, tags)
+		VALUES ($1, $2, 'bot', 'backend', 'backend services', '{" Go "}')`, f.botSpaceID, f.botIDs[1])
+	if err != nil {
+		t.Fatalf("failed to insert skill: %v", err)
+	}
+
+	lease := time.Now().Add(time.Minute)
+	claims := []struct {
+		botID string
+		want  *types.SpaceTask
+	}{
+		{botID: f.botIDs[0], want: &plain},
+		{botID: f.botIDs[2], want: nil},
+		{botID: f.botIDs[1], want: &skilled},
+	}
+	for _, claim := range claims {
+		got, err := f.db.ClaimNext(ctx, f.botSpaceID, claim.botID, lease)
+		if err != nil {
+			t.Fatalf("failed to claim task: %v", err)
+		}
+		switch {
+		case claim.want == nil && got != nil:
+			t.Errorf("bot %s claimed %s, want nothing", claim.botID, got.ID)
+		case claim.want != nil && (got == nil || got.ID != claim.want.ID):
+			t.Errorf("bot %s claimed %v, want %s", claim.botID, got, claim.want.ID)
+		}
+	}
+}
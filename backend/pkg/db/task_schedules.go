@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/innodv/psql"
+	"github.com/jmoiron/sqlx"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// schedulerLockKey is the advisory lock held by the replica materializing
+// due task schedules.
+const schedulerLockKey int64 = 7_326_001
+
+type taskScheduleDB struct {
+	db               *sqlx.DB
+	log              logrus.Ext1FieldLogger
+	conf             *config.Config
+	insert           *sqlx.NamedStmt
+	getByID          *sqlx.Stmt
+	listByBotSpaceID *sqlx.Stmt
+	listDue          *sqlx.Stmt
+	setPaused        *sqlx.Stmt
+	deleteStmt       *sqlx.Stmt
+}
+
+func NewTaskScheduleDB(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (TaskScheduleDB, error) {
+	cols := psql.GetSQLColumnsQuoted[types.TaskSchedule]()
+	colStr := strings.Join(cols, ", ")
+	rawCols := psql.GetSQLColumns[types.TaskSchedule]()
+
+	insert, err := sdb.PrepareNamedContext(ctx, fmt.Sprintf(
+		`INSERT INTO task_schedules (%s) VALUES (:%s) RETURNING %s`,
+		colStr, strings.Join(rawCols, ", :"), colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare insert statement")
+	}
+
+	getByID, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM task_schedules WHERE id = $1`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare getByID statement")
+	}
+
+	listByBotSpaceID, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM task_schedules WHERE bot_space_id = $1 ORDER BY created_at ASC`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listByBotSpaceID statement")
+	}
+
+	listDue, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`SELECT %s FROM task_schedules WHERE NOT paused AND next_run_at <= $1
+		ORDER BY next_run_at ASC LIMIT $2`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare listDue statement")
+	}
+
+	setPaused, err := sdb.PreparexContext(ctx, fmt.Sprintf(
+		`UPDATE task_schedules SET paused = $2, next_run_at = $3, updated_at = now()
+		WHERE id = $1 RETURNING %s`, colStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare setPaused statement")
+	}
+
+	deleteStmt, err := sdb.PreparexContext(ctx, `DELETE FROM task_schedules WHERE id = $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare delete statement")
+	}
+
+	return &taskScheduleDB{
+		db:               sdb,
+		log:              conf.GetLogger(),
+		conf:             conf,
+		insert:           insert,
+		getByID:          getByID,
+		listByBotSpaceID: listByBotSpaceID,
+		listDue:          listDue,
+		setPaused:        setPaused,
+		deleteStmt:       deleteStmt,
+	}, nil
+}
+
+func (t *taskScheduleDB) Insert(ctx context.Context, schedule types.TaskSchedule) (types.TaskSchedule, error) {
+	var result types.TaskSchedule
+	err := t.insert.GetContext(ctx, &result, schedule)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to insert task schedule")
+	}
+	return result, nil
+}
+
+func (t *taskScheduleDB) GetByID(ctx context.Context, id string) (types.TaskSchedule, error) {
+	var schedule types.TaskSchedule
+	err := t.getByID.GetContext(ctx, &schedule, id)
+	if err != nil {
+		return schedule, errors.Wrap(err, "failed to get task schedule")
+	}
+	return schedule, nil
+}
+
+func (t *taskScheduleDB) ListByBotSpaceID(ctx context.Context, botSpaceID string) ([]types.TaskSchedule, error) {
+	schedules := make([]types.TaskSchedule, 0)
+	err := t.listByBotSpaceID.SelectContext(ctx, &schedules, botSpaceID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list task schedules")
+	}
+	return schedules, nil
+}
+
+// ListDue returns up to limit unpaused schedules whose next run is at or
+// before now, the most overdue first.
+func (t *taskScheduleDB) ListDue(ctx context.Context, now time.Time, limit int) ([]types.TaskSchedule, error) {
+	schedules := make([]types.TaskSchedule, 0)
+	err := t.listDue.SelectContext(ctx, &schedules, now, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list due task schedules")
+	}
+	return schedules, nil
+}
+
+// SetPaused pauses or resumes a schedule. nextRunAt replaces the stored next
+// run so a resumed schedule does not fire for runs missed while paused.
+func (t *taskScheduleDB) SetPaused(ctx context.Context, id string, paused bool, nextRunAt time.Time) (types.TaskSchedule, error) {
+	var schedule types.TaskSchedule
+	err := t.setPaused.GetContext(ctx, &schedule, id, paused, nextRunAt)
+	if err != nil {
+		return schedule, errors.Wrap(err, "failed to set task schedule paused")
+	}
+	return schedule, nil
+}
+
+func (t *taskScheduleDB) Delete(ctx context.Context, id string) error {
+	_, err := t.deleteStmt.ExecContext(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete task schedule")
+	}
+	return nil
+}
+
+// WithSchedulerLock runs fn while holding the scheduler's advisory lock and
+// reports whether the lock was free. Replicas which find it taken skip the
+// run rather than wait.
+func (t *taskScheduleDB) WithSchedulerLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return withAdvisoryLock(ctx, t.db, t.log, schedulerLockKey, fn)
+}
+
+// withAdvisoryLock runs fn while holding the session advisory lock key and
+// reports whether the lock was free, without waiting for it.
+func withAdvisoryLock(ctx context.Context, sdb *sqlx.DB, log logrus.Ext1FieldLogger, key int64, fn func(ctx context.Context) error) (bool, error) {
+	// Session advisory locks belong to a connection, so pin one for the
+	// lock and unlock.
+	conn, err := sdb.Connx(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get connection")
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1)`, key); err != nil {
+		return false, errors.Wrap(err, "failed to take advisory lock")
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			log.WithError(err).WithField("key", key).Error("failed to release advisory lock")
+		}
+	}()
+
+	return true, fn(ctx)
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+	"github.com/numbergroup/claw-swarm/pkg/types"
+)
+
+type scheduleFixture struct {
+	tasks      SpaceTaskDB
+	schedules  TaskScheduleDB
+	botSpaceID string
+	botID      string
+}
+
+func newScheduleFixture(t *testing.T) scheduleFixture {
+	t.Helper()
+	conf, sdb := dbtest.New(t)
+	sdb.SetMaxOpenConns(racers + 1)
+	ctx := context.Background()
+
+	tasks, err := NewSpaceTaskDB(ctx, conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create space task db: %v", err)
+	}
+	schedules, err := NewTaskScheduleDB(ctx, conf, sdb)
+	if err != nil {
+		t.Fatalf("failed to create task schedule db: %v", err)
+	}
+	f := scheduleFixture{tasks: tasks, schedules: schedules, botSpaceID: dbtest.Space(t, sdb)}
+	f.botID = dbtest.Bot(t, sdb, f.botSpaceID, "bot")
+	return f
+}
+
+// dueSchedule inserts a schedule whose next run has just passed.
+func (f scheduleFixture) dueSchedule(t *testing.T) types.TaskSchedule {
+	t.Helper()
+	now := time.Now()
+	schedule, err := f.schedules.Insert(context.Background(), types.TaskSchedule{
+		ID:            uuid.New().String(),
+		BotSpaceID:    f.botSpaceID,
+		Name:          "nightly",
+		Cron:          "0 0 * * *",
+		Timezone:      "UTC",
+		NextRunAt:     now.Add(-time.Minute),
+		CreatedByType: types.ActorBot,
+		CreatedByID:   f.botID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	if err != nil {
+		t.Fatalf("failed to insert schedule: %v", err)
+	}
+	return schedule
+}
+
+// scheduledTask builds the task a run of schedule creates.
+func (f scheduleFixture) scheduledTask(schedule types.TaskSchedule) (types.SpaceTask, types.TaskEvent) {
+	now := time.Now()
+	task := types.SpaceTask{
+		ID:             uuid.New().String(),
+		BotSpaceID:     f.botSpaceID,
+		Name:           schedule.Name,
+		Status:         types.TaskStatusAvailable,
+		ScheduleID:     &schedule.ID,
+		CreatedByType:  schedule.CreatedByType,
+		CreatedByID:    schedule.CreatedByID,
+		Version:        1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		RequiredSkills: pq.StringArray{},
+		DependsOn:      []string{},
+	}
+	return task, taskEvent(task, types.TaskEventCreated)
+}
+
+func (f scheduleFixture) scheduledTaskCount(t *testing.T, schedule types.TaskSchedule) int {
+	t.Helper()
+	tasks, err := f.tasks.ListByBotSpaceID(context.Background(), f.botSpaceID, types.TaskFilter{})
+	if err != nil {
+		t.Fatalf("failed to list tasks: %v", err)
+	}
+	count := 0
+	for _, task := range tasks {
+		if task.ScheduleID != nil && *task.ScheduleID == schedule.ID {
+			count++
+		}
+	}
+	return count
+}
+
+// TestInsertFromScheduleOnce runs the same due run from every racer; exactly
+// one must create a task and the schedule must move on.
+func TestInsertFromScheduleOnce(t *testing.T) {
+	f := newScheduleFixture(t)
+	ctx := context.Background()
+	schedule := f.dueSchedule(t)
+	nextRunAt := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			task, event := f.scheduledTask(schedule)
+			ok, err := f.tasks.InsertFromSchedule(ctx, task, event, schedule, nextRunAt)
+			if err != nil {
+				t.Errorf("failed to insert scheduled task: %v", err)
+				return
+			}
+			if ok {
+				created.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := created.Load(); got != 1 {
+		t.Errorf("got %d runs created, want 1", got)
+	}
+	if got := f.scheduledTaskCount(t, schedule); got != 1 {
+		t.Errorf("got %d scheduled tasks, want 1", got)
+	}
+
+	got, err := f.schedules.GetByID(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("failed to get schedule: %v", err)
+	}
+	if !got.NextRunAt.Equal(nextRunAt) {
+		t.Errorf("got next run %v, want %v", got.NextRunAt, nextRunAt)
+	}
+	if got.LastRunAt == nil || !got.LastRunAt.Equal(schedule.NextRunAt) {
+		t.Errorf("got last run %v, want %v", got.LastRunAt, schedule.NextRunAt)
+	}
+}
+
+func TestInsertFromSchedulePaused(t *testing.T) {
+	f := newScheduleFixture(t)
+	ctx := context.Background()
+	schedule := f.dueSchedule(t)
+	if _, err := f.schedules.SetPaused(ctx, schedule.ID, true, schedule.NextRunAt); err != nil {
+		t.Fatalf("failed to pause schedule: %v", err)
+	}
+
+	task, event := f.scheduledTask(schedule)
+	ok, err := f.tasks.InsertFromSchedule(ctx, task, event, schedule, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to insert scheduled task: %v", err)
+	}
+	if ok {
+		t.Error("created a task from a paused schedule")
+	}
+	if got := f.scheduledTaskCount(t, schedule); got != 0 {
+		t.Errorf("got %d scheduled tasks, want 0", got)
+	}
+}
+
+// TestListDue lists only unpaused schedules whose next run has passed.
+func TestListDue(t *testing.T) {
+	f := newScheduleFixture(t)
+	ctx := context.Background()
+	due := f.dueSchedule(t)
+	paused := f.dueSchedule(t)
+	if _, err := f.schedules.SetPaused(ctx, paused.ID, true, paused.NextRunAt); err != nil {
+		t.Fatalf("failed to pause schedule: %v", err)
+	}
+	later := f.dueSchedule(t)
+	if _, err := f.schedules.SetPaused(ctx, later.ID, false, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to move schedule: %v", err)
+	}
+
+	got, err := f.schedules.ListDue(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("failed to list due schedules: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != due.ID {
+		t.Errorf("got due schedules %+v, want only %s", got, due.ID)
+	}
+}
+
+// TestSchedulerLockExclusive takes the scheduler lock while it is held; the
+// second caller must skip rather than run or wait.
+func TestSchedulerLockExclusive(t *testing.T) {
+	f := newScheduleFixture(t)
+	ctx := context.Background()
+
+	ran, err := f.schedules.WithSchedulerLock(ctx, func(ctx context.Context) error {
+		locked, err := f.schedules.WithSchedulerLock(ctx, func(context.Context) error {
+			t.Error("ran while the lock was held")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if locked {
+			t.Error("took the lock while it was held")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to run with the scheduler lock: %v", err)
+	}
+	if !ran {
+		t.Fatal("the scheduler lock was not free")
+	}
+
+	ran, err = f.schedules.WithSchedulerLock(ctx, func(context.Context) error { return nil })
+	if err != nil || !ran {
+		t.Errorf("got ran %v, err %v after release; want the lock free again", ran, err)
+	}
+}
@@ -0,0 +1,134 @@
+// Package dbtest gives tests a migrated Postgres schema of their own. Tests
+// using it are skipped unless TEST_DB is set; the connection comes from the
+// usual DBHOST, DBPORT, DBUSER, DBPASSWORD and DBNAME settings.
+package dbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/innodv/psql"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+)
+
+// New creates a schema named after a random suffix, applies every migration
+// in ops/migrations to it and returns a config and pool whose connections
+// use it. The schema is dropped when the test finishes.
+func New(t testing.TB) (*config.Config, *sqlx.DB) {
+	t.Helper()
+	if os.Getenv("TEST_DB") == "" {
+		t.Skip("TEST_DB is not set")
+	}
+
+	ctx := context.Background()
+	conf := &config.Config{}
+	var err error
+	conf.PSQL, err = psql.NewConfig()
+	if err != nil {
+		t.Fatalf("failed to read database config: %v", err)
+	}
+	connStr, err := conf.PSQLConnString()
+	if err != nil {
+		t.Fatalf("failed to build connection string: %v", err)
+	}
+
+	admin, err := sqlx.ConnectContext(ctx, "postgres", connStr)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer admin.Close()
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("failed to generate schema name: %v", err)
+	}
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	// The vector extension is database-wide, so it lives in public where
+	// every test schema can see it rather than in the first schema created.
+	if _, err := admin.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS vector SCHEMA public`); err != nil {
+		t.Fatalf("failed to create vector extension: %v", err)
+	}
+	if _, err := admin.ExecContext(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		cleanup, err := sqlx.Connect("postgres", connStr)
+		if err != nil {
+			t.Logf("failed to connect to drop schema %s: %v", schema, err)
+			return
+		}
+		defer cleanup.Close()
+		if _, err := cleanup.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Logf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+
+	sdb, err := sqlx.ConnectContext(ctx, "postgres", connStr+" search_path="+schema+",public")
+	if err != nil {
+		t.Fatalf("failed to connect to test schema: %v", err)
+	}
+	t.Cleanup(func() { sdb.Close() })
+
+	migrations, err := filepath.Glob(filepath.Join(migrationsDir(), "*.sql"))
+	if err != nil || len(migrations) == 0 {
+		t.Fatalf("failed to find migrations: %v", err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		query, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", filepath.Base(migration), err)
+		}
+		if _, err := sdb.ExecContext(ctx, string(query)); err != nil {
+			t.Fatalf("failed to apply %s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	return conf, sdb
+}
+
+// migrationsDir locates ops/migrations relative to this file, so tests find
+// it whichever package directory they run from.
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "ops", "migrations")
+}
+
+// Space inserts a bot space, and a user to own it, returning the space id.
+func Space(t testing.TB, sdb *sqlx.DB) string {
+	t.Helper()
+	var userID string
+	err := sdb.Get(&userID, `INSERT INTO users (email, password_hash)
+		VALUES ('owner-' || gen_random_uuid() || '@example.com', 'x') RETURNING id`)
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+
+	var spaceID string
+	err = sdb.Get(&spaceID, `INSERT INTO bot_spaces (owner_id, name, join_code, manager_join_code)
+		VALUES ($1, 'test space', gen_random_uuid(), gen_random_uuid()) RETURNING id`, userID)
+	if err != nil {
+		t.Fatalf("failed to insert bot space: %v", err)
+	}
+	return spaceID
+}
+
+// Bot inserts a bot named name into the space and returns its id.
+func Bot(t testing.TB, sdb *sqlx.DB, botSpaceID, name string) string {
+	t.Helper()
+	var botID string
+	err := sdb.Get(&botID, `INSERT INTO bots (bot_space_id, name) VALUES ($1, $2) RETURNING id`, botSpaceID, name)
+	if err != nil {
+		t.Fatalf("failed to insert bot: %v", err)
+	}
+	return botID
+}
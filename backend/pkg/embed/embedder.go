@@ -0,0 +1,128 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/numbergroup/claw-swarm/pkg/config"
+)
+
+const (
+	EmbedderBackendHash = "hash"
+	EmbedderBackendHTTP = "http"
+)
+
+// Dimensions is the length of every embedding, matching the vector columns
+// they are stored in.
+const Dimensions = 1536
+
+// Embedder turns texts into embeddings of Dimensions floats whose cosine
+// distance reflects how related the texts are. It returns one embedding per
+// text, in order. A text with nothing to embed, such as punctuation alone,
+// may get a zero embedding; see IsZero.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// IsZero reports whether every value of embedding is zero. A zero embedding
+// has no cosine distance to anything, so it must be neither stored nor
+// searched with.
+func IsZero(embedding []float32) bool {
+	for _, v := range embedding {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Truncate cuts text to at most maxChars characters, keeping characters
+// whole. Providers reject input over their limit, so texts are cut to fit
+// rather than not embedded at all.
+func Truncate(text string, maxChars int) string {
+	if maxChars <= 0 || len(text) <= maxChars {
+		return text
+	}
+	chars := 0
+	for i := range text {
+		if chars == maxChars {
+			return text[:i]
+		}
+		chars++
+	}
+	return text
+}
+
+// NewEmbedder returns the backend selected by conf.EmbedderBackend.
+func NewEmbedder(conf *config.Config) (Embedder, error) {
+	switch conf.EmbedderBackend {
+	case "", EmbedderBackendHash:
+		return NewHashEmbedder(), nil
+	case EmbedderBackendHTTP:
+		return NewHTTPEmbedder(conf)
+	default:
+		return nil, fmt.Errorf("unknown embedder backend %q", conf.EmbedderBackend)
+	}
+}
+
+type hashEmbedder struct{}
+
+// NewHashEmbedder returns an embedder which needs no model or network. It
+// hashes the words of a text and each pair of adjacent words into signed
+// buckets, so texts sharing vocabulary land close together. The same text
+// always yields the same embedding, and a text without letters or digits
+// yields a zero one.
+func NewHashEmbedder() Embedder {
+	return hashEmbedder{}
+}
+
+func (hashEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = hashEmbed(text)
+	}
+	return embeddings, nil
+}
+
+func hashEmbed(text string) []float32 {
+	vec := make([]float32, Dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		addFeature(vec, word)
+		if i > 0 {
+			addFeature(vec, words[i-1]+" "+word)
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vec
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vec {
+		vec[i] *= scale
+	}
+	return vec
+}
+
+// addFeature adds feature to the bucket its hash selects, with the sign
+// taken from the hash's top bit so unrelated features tend to cancel out.
+func addFeature(vec []float32, feature string) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(feature))
+	sum := h.Sum64()
+	if sum>>63 == 1 {
+		vec[sum%Dimensions]--
+	} else {
+		vec[sum%Dimensions]++
+	}
+}
@@ -0,0 +1,120 @@
+package embed
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHashEmbedderDeterministic(t *testing.T) {
+	texts := []string{"deploy the api to staging", "Deploy the API to staging!", "write release notes"}
+	first, err := NewHashEmbedder().Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	second, err := NewHashEmbedder().Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Error("embedding the same texts twice gave different embeddings")
+	}
+	// Case and punctuation are not features.
+	if !reflect.DeepEqual(first[0], first[1]) {
+		t.Error("texts differing only in case and punctuation gave different embeddings")
+	}
+	if reflect.DeepEqual(first[0], first[2]) {
+		t.Error("unrelated texts gave the same embedding")
+	}
+}
+
+func TestHashEmbedderNormalized(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantNorm float64
+	}{
+		{name: "single word", text: "deploy", wantNorm: 1},
+		{name: "sentence", text: "deploy the api to staging and then deploy again", wantNorm: 1},
+		{name: "empty", text: "", wantNorm: 0},
+		{name: "punctuation only", text: "?!", wantNorm: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embeddings, err := NewHashEmbedder().Embed(context.Background(), []string{tt.text})
+			if err != nil {
+				t.Fatalf("failed to embed: %v", err)
+			}
+			if len(embeddings) != 1 || len(embeddings[0]) != Dimensions {
+				t.Fatalf("got %d embeddings, want 1 of %d dimensions", len(embeddings), Dimensions)
+			}
+			var norm float64
+			for _, v := range embeddings[0] {
+				norm += float64(v) * float64(v)
+			}
+			if norm = math.Sqrt(norm); math.Abs(norm-tt.wantNorm) > 1e-5 {
+				t.Errorf("got norm %v, want %v", norm, tt.wantNorm)
+			}
+		})
+	}
+}
+
+func TestHashEmbedderRelatedTextsCloser(t *testing.T) {
+	embeddings, err := NewHashEmbedder().Embed(context.Background(), []string{
+		"deploy the api to staging",
+		"deploy the api to production",
+		"bake a chocolate cake",
+	})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	related, unrelated := dot(embeddings[0], embeddings[1]), dot(embeddings[0], embeddings[2])
+	if related <= unrelated {
+		t.Errorf("got similarity %v for related texts and %v for unrelated ones", related, unrelated)
+	}
+}
+
+// dot is the cosine similarity of two unit vectors.
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func TestIsZero(t *testing.T) {
+	embeddings, err := NewHashEmbedder().Embed(context.Background(), []string{"deploy", "", "?!"})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	for i, want := range []bool{false, true, true} {
+		if got := IsZero(embeddings[i]); got != want {
+			t.Errorf("IsZero(embedding %d) = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxChars int
+		want     string
+	}{
+		{name: "shorter", text: "deploy", maxChars: 10, want: "deploy"},
+		{name: "exact", text: "deploy", maxChars: 6, want: "deploy"},
+		{name: "longer", text: "deploy the api", maxChars: 6, want: "deploy"},
+		{name: "multibyte", text: "déployer", maxChars: 3, want: "dép"},
+		{name: "multibyte within the byte limit", text: "ééé", maxChars: 4, want: "ééé"},
+		{name: "no limit", text: "deploy", maxChars: 0, want: "deploy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Truncate(tt.text, tt.maxChars); got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.text, tt.maxChars, got, tt.want)
+			}
+		})
+	}
+}
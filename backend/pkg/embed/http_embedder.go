@@ -0,0 +1,102 @@
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/numbergroup/claw-swarm/pkg/config"
+	"github.com/numbergroup/errors"
+)
+
+// maxErrorBody caps how much of a failed response is kept in the error.
+const maxErrorBody = 1024
+
+type httpEmbedder struct {
+	client *http.Client
+	url    string
+	apiKey string
+	model  string
+}
+
+// NewHTTPEmbedder returns an embedder backed by a provider speaking the
+// OpenAI embeddings API at conf.EmbedderURL. The model must produce
+// embeddings of Dimensions floats.
+func NewHTTPEmbedder(conf *config.Config) (Embedder, error) {
+	if conf.EmbedderURL == "" {
+		return nil, errors.New("EMBEDDER_URL is required for the http embedder")
+	}
+	return &httpEmbedder{
+		client: &http.Client{Timeout: conf.EmbedderTimeout},
+		url:    conf.EmbedderURL,
+		apiKey: conf.EmbedderAPIKey,
+		model:  conf.EmbedderModel,
+	}, nil
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *httpEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return [][]float32{}, nil
+	}
+
+	body, err := json.Marshal(embeddingRequest{Model: e.model, Input: texts})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal embedding request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build embedding request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to request embeddings")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, errors.Errorf("embedding provider returned %d: %s", resp.StatusCode, msg)
+	}
+
+	var parsed embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, errors.Wrap(err, "failed to decode embedding response")
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, d := range parsed.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, errors.Errorf("embedding provider returned unknown index %d", d.Index)
+		}
+		if len(d.Embedding) != Dimensions {
+			return nil, errors.Errorf("embedding provider returned %d dimensions, want %d", len(d.Embedding), Dimensions)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return nil, errors.Errorf("embedding provider returned no embedding for input %d", i)
+		}
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,256 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+	"github.com/numbergroup/errors"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	// LegacyKID names the key built from JWT_SECRET. Tokens signed before
+	// key ids existed carry no kid header and are verified against it.
+	LegacyKID = "legacy"
+)
+
+// KeySpec describes one key in JWT_KEYS. HS256 keys take a Secret; RS256 and
+// EdDSA keys take PEM encoded keys. A key with only a PublicKey can verify
+// but not sign. Retired keys are kept in the file for reference but no
+// longer verify anything.
+type KeySpec struct {
+	KID        string `json:"kid"`
+	Alg        string `json:"alg"`
+	Secret     string `json:"secret,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	PublicKey  string `json:"publicKey,omitempty"`
+	Retired    bool   `json:"retired,omitempty"`
+}
+
+// Spec is the JSON shape of JWT_KEYS.
+type Spec struct {
+	Active string    `json:"active"`
+	Keys   []KeySpec `json:"keys"`
+}
+
+type key struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+// Keyring signs tokens with the active key and verifies them against any key
+// which has not been retired, so keys can be rotated without invalidating
+// every outstanding token at once.
+type Keyring struct {
+	active *key
+	keys   map[string]*key
+}
+
+// New builds the keyring from JWT_KEYS and JWT_SECRET. JWT_SECRET, when set,
+// is loaded as the HS256 key LegacyKID and is the active key unless JWT_KEYS
+// names another. JWT_KEYS may declare LegacyKID itself to replace that key
+// or, with retired set, to stop accepting it.
+func New(conf *config.Config) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*key)}
+
+	spec := Spec{}
+	if conf.JWTKeys != "" {
+		if err := json.Unmarshal([]byte(conf.JWTKeys), &spec); err != nil {
+			return nil, errors.Wrap(err, "failed to parse JWT_KEYS")
+		}
+	}
+	if conf.JWTSecret != "" {
+		spec.addLegacy(conf.JWTSecret)
+	}
+
+	for _, ks := range spec.Keys {
+		if ks.Retired {
+			continue
+		}
+		if ks.KID == "" {
+			return nil, errors.New("every key in JWT_KEYS needs a kid")
+		}
+		if _, dup := k.keys[ks.KID]; dup {
+			return nil, errors.Errorf("duplicate key id %q", ks.KID)
+		}
+		parsed, err := parseKey(ks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load key %q", ks.KID)
+		}
+		k.keys[ks.KID] = parsed
+	}
+
+	active, ok := k.keys[spec.Active]
+	if !ok {
+		return nil, errors.Errorf("active signing key %q is not a loaded key", spec.Active)
+	}
+	if active.signKey == nil {
+		return nil, errors.Errorf("active signing key %q has no private key", spec.Active)
+	}
+	k.active = active
+	return k, nil
+}
+
+// addLegacy adds JWT_SECRET as the key LegacyKID. An entry for LegacyKID in
+// JWT_KEYS takes precedence; if it is an HS256 entry without a secret, it
+// takes JWT_SECRET as its secret, so the key can be retired without copying
+// the secret into JWT_KEYS.
+func (s *Spec) addLegacy(secret string) {
+	for i := range s.Keys {
+		ks := &s.Keys[i]
+		if ks.KID != LegacyKID {
+			continue
+		}
+		if ks.Alg == AlgHS256 && ks.Secret == "" {
+			ks.Secret = secret
+		}
+		if s.Active == "" && !ks.Retired {
+			s.Active = LegacyKID
+		}
+		return
+	}
+
+	s.Keys = append(s.Keys, KeySpec{KID: LegacyKID, Alg: AlgHS256, Secret: secret})
+	if s.Active == "" {
+		s.Active = LegacyKID
+	}
+}
+
+func parseKey(ks KeySpec) (*key, error) {
+	k := &key{kid: ks.KID}
+	var err error
+	switch ks.Alg {
+	case AlgHS256:
+		if ks.Secret == "" {
+			return nil, errors.New("HS256 keys need a secret")
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = []byte(ks.Secret)
+		k.verifyKey = k.signKey
+	case AlgRS256:
+		k.method = jwt.SigningMethodRS256
+		if ks.PrivateKey != "" {
+			var priv *rsa.PrivateKey
+			if priv, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(ks.PrivateKey)); err != nil {
+				return nil, err
+			}
+			k.signKey = priv
+			k.verifyKey = &priv.PublicKey
+		} else if ks.PublicKey != "" {
+			if k.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(ks.PublicKey)); err != nil {
+				return nil, err
+			}
+		}
+	case AlgEdDSA:
+		k.method = jwt.SigningMethodEdDSA
+		if ks.PrivateKey != "" {
+			priv, err := jwt.ParseEdPrivateKeyFromPEM([]byte(ks.PrivateKey))
+			if err != nil {
+				return nil, err
+			}
+			edPriv, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("not an Ed25519 private key")
+			}
+			k.signKey = edPriv
+			k.verifyKey = edPriv.Public()
+		} else if ks.PublicKey != "" {
+			if k.verifyKey, err = jwt.ParseEdPublicKeyFromPEM([]byte(ks.PublicKey)); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.Errorf("unsupported algorithm %q", ks.Alg)
+	}
+	if k.verifyKey == nil {
+		return nil, errors.New("key has neither a private nor a public key")
+	}
+	return k, nil
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.kid
+	return token.SignedString(k.active.signKey)
+}
+
+// Keyfunc resolves the verification key for token from its kid header. It is
+// meant to be passed to jwt.Parse.
+func (k *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = LegacyKID
+	}
+	found, ok := k.keys[kid]
+	if !ok {
+		return nil, errors.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != found.method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return found.verifyKey, nil
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	KTY string `json:"kty"`
+	KID string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of every asymmetric key which can currently
+// verify tokens. Shared HS256 secrets are never published.
+func (k *Keyring) JWKS() JWKSet {
+	kids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKSet{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		key := k.keys[kid]
+		switch pub := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KTY: "OKP",
+				KID: key.kid,
+				Alg: AlgEdDSA,
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KTY: "RSA",
+				KID: key.kid,
+				Alg: AlgRS256,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	return set
+}
@@ -0,0 +1,110 @@
+// Package routing ranks the bots of a space for a task by how many of the
+// task's required skills they have, whether they are around to pick it up
+// and how much work they already hold.
+// Ranking is a pure function of its input, so equal input always yields the
+// same order.
+package routing
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
+)
+
+// Candidate is a bot considered for a task, with its skills and the
+// unfinished tasks it holds. Bot.Presence must be filled in; Rank treats a
+// bot with no presence as online.
+type Candidate struct {
+	Bot    types.Bot
+	Skills []types.BotSkill
+	Load   types.BotTaskLoad
+}
+
+// NormalizeSkills lower-cases and trims skills, dropping blanks and
+// duplicates while keeping the first occurrence's position.
+func NormalizeSkills(skills []string) []string {
+	normalized := make([]string, 0, len(skills))
+	seen := make(map[string]bool, len(skills))
+	for _, skill := range skills {
+		skill = strings.ToLower(strings.TrimSpace(skill))
+		if skill == "" || seen[skill] {
+			continue
+		}
+		seen[skill] = true
+		normalized = append(normalized, skill)
+	}
+	return normalized
+}
+
+// Rank returns a suggestion for every free candidate, best first. A bot is
+// free if it has no task in progress, is not muted and is not offline. A
+// required skill is matched by a skill name or tag of the bot, ignoring
+// case; when skills are required, bots matching none of them are left out.
+//
+// Suggestions are ordered by the number of required skills matched, then
+// online bots before idle ones, then by the fewest unfinished tasks held,
+// then by bot name and finally bot id.
+func Rank(required []string, candidates []Candidate) []types.TaskSuggestion {
+	required = NormalizeSkills(required)
+
+	suggestions := make([]types.TaskSuggestion, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.Load.Active > 0 || candidate.Bot.IsMuted {
+			continue
+		}
+		presence := candidate.Bot.Presence
+		if presence == "" {
+			presence = ws.PresenceOnline
+		}
+		if presence == ws.PresenceOffline {
+			continue
+		}
+
+		has := make(map[string]bool)
+		for _, skill := range candidate.Skills {
+			has[strings.ToLower(strings.TrimSpace(skill.Name))] = true
+			for _, tag := range skill.Tags {
+				has[strings.ToLower(strings.TrimSpace(tag))] = true
+			}
+		}
+
+		matched := make([]string, 0, len(required))
+		for _, skill := range required {
+			if has[skill] {
+				matched = append(matched, skill)
+			}
+		}
+		if len(required) > 0 && len(matched) == 0 {
+			continue
+		}
+
+		suggestions = append(suggestions, types.TaskSuggestion{
+			BotID:         candidate.Bot.ID,
+			BotName:       candidate.Bot.Name,
+			MatchedSkills: matched,
+			Score:         len(matched),
+			Load:          candidate.Load.Open,
+			Presence:      presence,
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Presence != b.Presence {
+			return a.Presence == ws.PresenceOnline
+		}
+		if a.Load != b.Load {
+			return a.Load < b.Load
+		}
+		if a.BotName != b.BotName {
+			return a.BotName < b.BotName
+		}
+		return a.BotID < b.BotID
+	})
+	return suggestions
+}
@@ -0,0 +1,175 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/numbergroup/claw-swarm/pkg/ws"
+)
+
+func candidate(id, name string, active, open int, skills ...types.BotSkill) Candidate {
+	return Candidate{
+		Bot:    types.Bot{ID: id, Name: name, Presence: ws.PresenceOnline},
+		Skills: skills,
+		Load:   types.BotTaskLoad{BotID: id, Active: active, Open: open},
+	}
+}
+
+// with returns candidate with its bot changed by change.
+func with(c Candidate, change func(bot *types.Bot)) Candidate {
+	change(&c.Bot)
+	return c
+}
+
+func muted(bot *types.Bot)   { bot.IsMuted = true }
+func idle(bot *types.Bot)    { bot.Presence = ws.PresenceIdle }
+func offline(bot *types.Bot) { bot.Presence = ws.PresenceOffline }
+
+func skill(name string, tags ...string) types.BotSkill {
+	return types.BotSkill{Name: name, Tags: tags}
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name       string
+		required   []string
+		candidates []Candidate
+		want       []types.TaskSuggestion
+	}{
+		{
+			name:     "scores by required skills matched",
+			required: []string{"go", "sql", "docker"},
+			candidates: []Candidate{
+				candidate("a", "alpha", 0, 0, skill("go")),
+				candidate("b", "bravo", 0, 0, skill("Go"), skill("databases", "SQL", "docker")),
+				candidate("c", "charlie", 0, 0, skill("go"), skill("sql")),
+			},
+			want: []types.TaskSuggestion{
+				{BotID: "b", BotName: "bravo", MatchedSkills: []string{"go", "sql", "docker"}, Score: 3, Presence: ws.PresenceOnline},
+				{BotID: "c", BotName: "charlie", MatchedSkills: []string{"go", "sql"}, Score: 2, Presence: ws.PresenceOnline},
+				{BotID: "a", BotName: "alpha", MatchedSkills: []string{"go"}, Score: 1, Presence: ws.PresenceOnline},
+			},
+		},
+		{
+			name:     "leaves out busy bots and bots matching nothing",
+			required: []string{" Go ", "go"},
+			candidates: []Candidate{
+				candidate("a", "alpha", 1, 1, skill("go")),
+				candidate("b", "bravo", 0, 0, skill("python")),
+				candidate("c", "charlie", 0, 2, skill("go")),
+			},
+			want: []types.TaskSuggestion{
+				{BotID: "c", BotName: "charlie", MatchedSkills: []string{"go"}, Score: 1, Load: 2, Presence: ws.PresenceOnline},
+			},
+		},
+		{
+			name:     "breaks score ties by fewest open tasks",
+			required: []string{"go"},
+			candidates: []Candidate{
+				candidate("a", "alpha", 0, 3, skill("go")),
+				candidate("b", "bravo", 0, 1, skill("go")),
+				candidate("c", "charlie", 0, 2, skill("go")),
+			},
+			want: []types.TaskSuggestion{
+				{BotID: "b", BotName: "bravo", MatchedSkills: []string{"go"}, Score: 1, Load: 1, Presence: ws.PresenceOnline},
+				{BotID: "c", BotName: "charlie", MatchedSkills: []string{"go"}, Score: 1, Load: 2, Presence: ws.PresenceOnline},
+				{BotID: "a", BotName: "alpha", MatchedSkills: []string{"go"}, Score: 1, Load: 3, Presence: ws.PresenceOnline},
+			},
+		},
+		{
+			name:     "orders equal candidates by name then id",
+			required: nil,
+			candidates: []Candidate{
+				candidate("2", "bravo", 0, 0),
+				candidate("3", "alpha", 0, 0),
+				candidate("1", "bravo", 0, 0),
+			},
+			want: []types.TaskSuggestion{
+				{BotID: "3", BotName: "alpha", MatchedSkills: []string{}, Presence: ws.PresenceOnline},
+				{BotID: "1", BotName: "bravo", MatchedSkills: []string{}, Presence: ws.PresenceOnline},
+				{BotID: "2", BotName: "bravo", MatchedSkills: []string{}, Presence: ws.PresenceOnline},
+			},
+		},
+		{
+			name:     "leaves out muted and offline bots",
+			required: []string{"go"},
+			candidates: []Candidate{
+				with(candidate("a", "alpha", 0, 0, skill("go")), muted),
+				with(candidate("b", "bravo", 0, 0, skill("go")), offline),
+				candidate("c", "charlie", 0, 0, skill("go")),
+			},
+			want: []types.TaskSuggestion{
+				{BotID: "c", BotName: "charlie", MatchedSkills: []string{"go"}, Score: 1, Presence: ws.PresenceOnline},
+			},
+		},
+		{
+			name:     "ranks idle bots below online bots matching as many skills",
+			required: []string{"go", "sql"},
+			candidates: []Candidate{
+				with(candidate("a", "alpha", 0, 0, skill("go"), skill("sql")), idle),
+				candidate("b", "bravo", 0, 3, skill("go")),
+				with(candidate("c", "charlie", 0, 0, skill("go")), idle),
+				candidate("d", "delta", 0, 5, skill("go"), skill("sql")),
+			},
+			want: []types.TaskSuggestion{
+				{BotID: "d", BotName: "delta", MatchedSkills: []string{"go", "sql"}, Score: 2, Load: 5, Presence: ws.PresenceOnline},
+				{BotID: "a", BotName: "alpha", MatchedSkills: []string{"go", "sql"}, Score: 2, Presence: ws.PresenceIdle},
+				{BotID: "b", BotName: "bravo", MatchedSkills: []string{"go"}, Score: 1, Load: 3, Presence: ws.PresenceOnline},
+				{BotID: "c", BotName: "charlie", MatchedSkills: []string{"go"}, Score: 1, Presence: ws.PresenceIdle},
+			},
+		},
+		{
+			name:     "treats a bot without presence as online",
+			required: []string{"go"},
+			candidates: []Candidate{
+				with(candidate("a", "alpha", 0, 0, skill("go")), func(bot *types.Bot) { bot.Presence = "" }),
+			},
+			want: []types.TaskSuggestion{
+				{BotID: "a", BotName: "alpha", MatchedSkills: []string{"go"}, Score: 1, Presence: ws.PresenceOnline},
+			},
+		},
+		{
+			name:       "no candidates",
+			required:   []string{"go"},
+			candidates: nil,
+			want:       []types.TaskSuggestion{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Rank(tt.required, tt.candidates)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rank() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRankOrderIgnoresInputOrder ranks the same candidates in every rotation
+// and expects one order.
+func TestRankOrderIgnoresInputOrder(t *testing.T) {
+	candidates := []Candidate{
+		candidate("a", "same", 0, 1, skill("go")),
+		candidate("b", "same", 0, 1, skill("go")),
+		candidate("c", "same", 0, 0, skill("go")),
+		candidate("d", "other", 0, 1, skill("go", "sql")),
+	}
+	want := Rank([]string{"go", "sql"}, candidates)
+
+	for i := 1; i < len(candidates); i++ {
+		rotated := append(append([]Candidate{}, candidates[i:]...), candidates[:i]...)
+		if got := Rank([]string{"go", "sql"}, rotated); !reflect.DeepEqual(got, want) {
+			t.Errorf("rotation %d: Rank() = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestNormalizeSkills(t *testing.T) {
+	got := NormalizeSkills([]string{" Go", "", "SQL ", "go", "  "})
+	want := []string{"go", "sql"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeSkills() = %q, want %q", got, want)
+	}
+}
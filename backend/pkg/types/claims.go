@@ -8,5 +8,8 @@ type Claims struct {
 	UserID     string `json:"userId,omitempty"`
 	BotSpaceID string `json:"botSpaceId,omitempty"`
 	BotID      string `json:"botId,omitempty"`
-	IsManager  bool   `json:"isManager,omitempty"`
+	// IsManager is informational in the token; the auth middleware replaces
+	// it and IsMuted with the bot's live state on every request.
+	IsManager bool `json:"isManager,omitempty"`
+	IsMuted   bool `json:"-"`
 }
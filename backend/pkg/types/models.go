@@ -17,15 +17,16 @@ type User struct {
 }
 
 type BotSpace struct {
-	ID              string    `json:"id" db:"id"`
-	OwnerID         string    `json:"ownerId" db:"owner_id"`
-	Name            string    `json:"name" db:"name"`
-	Description     *string   `json:"description" db:"description"`
-	JoinCode        string    `json:"joinCode" db:"join_code"`
-	ManagerJoinCode string    `json:"managerJoinCode" db:"manager_join_code"`
-	ManagerBotID    *string   `json:"managerBotId" db:"manager_bot_id"`
-	CreatedAt       time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt       time.Time `json:"updatedAt" db:"updated_at"`
+	ID                 string    `json:"id" db:"id"`
+	OwnerID            string    `json:"ownerId" db:"owner_id"`
+	Name               string    `json:"name" db:"name"`
+	Description        *string   `json:"description" db:"description"`
+	JoinCode           string    `json:"joinCode" db:"join_code"`
+	ManagerJoinCode    string    `json:"managerJoinCode" db:"manager_join_code"`
+	ManagerBotID       *string   `json:"managerBotId" db:"manager_bot_id"`
+	RequiresTaskReview bool      `json:"requiresTaskReview" db:"requires_task_review"`
+	CreatedAt          time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt          time.Time `json:"updatedAt" db:"updated_at"`
 }
 
 type SpaceMember struct {
@@ -52,6 +53,29 @@ type Bot struct {
 	LastSeenAt   *time.Time `json:"lastSeenAt" db:"last_seen_at"`
 	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
 	UpdatedAt    time.Time  `json:"updatedAt" db:"updated_at"`
+	// Presence is derived at request time and not stored.
+	Presence string `json:"presence,omitempty" db:"-"`
+}
+
+// BotAuth is the subset of a bot consulted when authorizing its requests and
+// attributing them to it.
+type BotAuth struct {
+	ID         string `json:"id" db:"id"`
+	BotSpaceID string `json:"botSpaceId" db:"bot_space_id"`
+	Name       string `json:"name" db:"name"`
+	IsManager  bool   `json:"isManager" db:"is_manager"`
+	IsMuted    bool   `json:"isMuted" db:"is_muted"`
+	// TokensRevokedBefore rejects tokens issued before it.
+	TokensRevokedBefore *time.Time `json:"-" db:"tokens_revoked_before"`
+}
+
+type BotToken struct {
+	ID         string     `json:"id" db:"id"`
+	BotID      string     `json:"botId" db:"bot_id"`
+	BotSpaceID string     `json:"botSpaceId" db:"bot_space_id"`
+	IssuedAt   time.Time  `json:"issuedAt" db:"issued_at"`
+	ExpiresAt  time.Time  `json:"expiresAt" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revokedAt" db:"revoked_at"`
 }
 
 type Message struct {
@@ -64,6 +88,22 @@ type Message struct {
 	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
 }
 
+// MessageMatch is a message found by full-text search. Snippet holds the
+// fragments of its content around the matching terms, which are wrapped in
+// **.
+type MessageMatch struct {
+	Message
+	Rank    float64 `json:"rank" db:"rank"`
+	Snippet string  `json:"snippet" db:"snippet"`
+}
+
+// RecalledMessage is a message found by semantic recall, with its cosine
+// distance from the query; 0 is identical.
+type RecalledMessage struct {
+	Message
+	Distance float64 `json:"distance" db:"distance"`
+}
+
 type BotStatus struct {
 	ID             string    `json:"id" db:"id"`
 	BotSpaceID     string    `json:"botSpaceId" db:"bot_space_id"`
@@ -92,6 +132,18 @@ type InviteCode struct {
 	ExpiresAt  *time.Time `json:"expiresAt" db:"expires_at"`
 }
 
+const (
+	TaskStatusWaiting    = "waiting"
+	TaskStatusAvailable  = "available"
+	TaskStatusInProgress = "in_progress"
+	TaskStatusInReview   = "in_review"
+	TaskStatusCompleted  = "completed"
+	TaskStatusBlocked    = "blocked"
+	TaskStatusProposed   = "proposed"
+	TaskStatusRejected   = "rejected"
+	TaskStatusCancelled  = "cancelled"
+)
+
 type SpaceTask struct {
 	ID             string     `json:"id" db:"id"`
 	BotSpaceID     string     `json:"botSpaceId" db:"bot_space_id"`
@@ -99,12 +151,133 @@ type SpaceTask struct {
 	Description    string     `json:"description" db:"description"`
 	Status         string     `json:"status" db:"status"`
 	BotID          *string    `json:"botId" db:"bot_id"`
-	CreatedByBotID string     `json:"createdByBotId" db:"created_by_bot_id"`
+	ParentTaskID   *string    `json:"parentTaskId" db:"parent_task_id"`
+	ScheduleID     *string    `json:"scheduleId" db:"schedule_id"`
+	Priority       int        `json:"priority" db:"priority"`
+	DueAt          *time.Time `json:"dueAt" db:"due_at"`
+	LeaseExpiresAt *time.Time `json:"leaseExpiresAt" db:"lease_expires_at"`
+	RequiresReview bool       `json:"requiresReview" db:"requires_review"`
+	CreatedByType  string     `json:"createdByType" db:"created_by_type"`
+	CreatedByID    string     `json:"createdById" db:"created_by_id"`
 	CompletedAt    *time.Time `json:"completedAt" db:"completed_at"`
+	Version        int        `json:"version" db:"version"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updatedAt" db:"updated_at"`
+	// RequiredSkills are lower-cased skill names or tags.
+	RequiredSkills pq.StringArray `json:"requiredSkills" db:"required_skills"`
+	// DependsOn is stored in space_task_dependencies.
+	DependsOn []string `json:"dependsOn" db:"-"`
+	// Progress rolls up the task's subtasks and is nil for leaf tasks.
+	Progress *TaskProgress `json:"progress,omitempty" db:"-"`
+}
+
+// TaskSchedule is a task template which the scheduler creates a task from
+// every time its cron expression fires.
+type TaskSchedule struct {
+	ID             string     `json:"id" db:"id"`
+	BotSpaceID     string     `json:"botSpaceId" db:"bot_space_id"`
+	Name           string     `json:"name" db:"name"`
+	Description    string     `json:"description" db:"description"`
+	Cron           string     `json:"cron" db:"cron"`
+	Timezone       string     `json:"timezone" db:"timezone"`
+	Priority       int        `json:"priority" db:"priority"`
+	RequiresReview bool       `json:"requiresReview" db:"requires_review"`
+	Paused         bool       `json:"paused" db:"paused"`
+	NextRunAt      time.Time  `json:"nextRunAt" db:"next_run_at"`
+	LastRunAt      *time.Time `json:"lastRunAt" db:"last_run_at"`
+	CreatedByType  string     `json:"createdByType" db:"created_by_type"`
+	CreatedByID    string     `json:"createdById" db:"created_by_id"`
 	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
 	UpdatedAt      time.Time  `json:"updatedAt" db:"updated_at"`
 }
 
+// Task event types.
+const (
+	TaskEventCreated   = "created"
+	TaskEventProposed  = "proposed"
+	TaskEventApproved  = "approved"
+	TaskEventRejected  = "rejected"
+	TaskEventAssigned  = "assigned"
+	TaskEventAccepted  = "accepted"
+	TaskEventSubmitted = "submitted"
+	TaskEventCompleted = "completed"
+	TaskEventBlocked   = "blocked"
+	TaskEventUnlocked  = "unlocked"
+	TaskEventReleased  = "released"
+	TaskEventUnblocked = "unblocked"
+	TaskEventCancelled = "cancelled"
+	TaskEventHandedOff = "handed_off"
+)
+
+// Task event types for decisions reviewers, managers and owners make about
+// someone else's task.
+const (
+	TaskEventReviewApproved   = "review_approved"
+	TaskEventChangesRequested = "changes_requested"
+	TaskEventReassigned       = "reassigned"
+)
+
+// Task event actor types. System events are made by the server itself and
+// have no actor id.
+const (
+	ActorBot    = "bot"
+	ActorUser   = "user"
+	ActorSystem = "system"
+)
+
+// TaskEvent is an entry in a task's append-only audit log: who moved it from
+// one status to another, along with the payload reported with it.
+type TaskEvent struct {
+	ID          string         `json:"id" db:"id"`
+	TaskID      string         `json:"taskId" db:"task_id"`
+	BotSpaceID  string         `json:"botSpaceId" db:"bot_space_id"`
+	Type        string         `json:"type" db:"type"`
+	ActorType   string         `json:"actorType" db:"actor_type"`
+	ActorID     *string        `json:"actorId" db:"actor_id"`
+	FromStatus  *string        `json:"fromStatus" db:"from_status"`
+	ToStatus    string         `json:"toStatus" db:"to_status"`
+	Note        *string        `json:"note" db:"note"`
+	Result      *string        `json:"result" db:"result"`
+	Reason      *string        `json:"reason" db:"reason"`
+	ArtifactIDs pq.StringArray `json:"artifactIds" db:"artifact_ids"`
+	CreatedAt   time.Time      `json:"createdAt" db:"created_at"`
+}
+
+// TaskDetail is a task with its event log and the artifacts linked to it.
+type TaskDetail struct {
+	SpaceTask
+	Events    []TaskEvent `json:"events"`
+	Artifacts []Artifact  `json:"artifacts"`
+}
+
+// ReleasedTask is a task whose lease lapsed, with the bot it was taken from.
+// ReleasedBotID is nil if that bot has since been removed.
+type ReleasedTask struct {
+	SpaceTask
+	ReleasedBotID *string `db:"released_bot_id"`
+}
+
+// BotTaskLoad counts the unfinished tasks held by a bot. Active is the task
+// in progress, at most one; Open adds those in review or blocked.
+type BotTaskLoad struct {
+	BotID  string `json:"botId" db:"bot_id"`
+	Active int    `json:"active" db:"active"`
+	Open   int    `json:"open" db:"open"`
+}
+
+// TaskProgress counts a task's approved subtasks and how many of them are
+// completed.
+type TaskProgress struct {
+	Completed int `json:"completed" db:"completed"`
+	Total     int `json:"total" db:"total"`
+}
+
+// TaskTree is a task with its subtasks nested beneath it.
+type TaskTree struct {
+	SpaceTask
+	Children []*TaskTree `json:"children"`
+}
+
 type Artifact struct {
 	ID             string    `json:"id" db:"id"`
 	BotSpaceID     string    `json:"botSpaceId" db:"bot_space_id"`
@@ -116,6 +289,15 @@ type Artifact struct {
 	UpdatedAt      time.Time `json:"updatedAt" db:"updated_at"`
 }
 
+// ArtifactMatch is an artifact found by full-text search. Snippet holds the
+// fragments of its description and data around the matching terms, which are
+// wrapped in **.
+type ArtifactMatch struct {
+	Artifact
+	Rank    float64 `json:"rank" db:"rank"`
+	Snippet string  `json:"snippet" db:"snippet"`
+}
+
 type BotSkill struct {
 	ID          string           `json:"id" db:"id"`
 	BotSpaceID  string           `json:"botSpaceId" db:"bot_space_id"`
@@ -128,3 +310,13 @@ type BotSkill struct {
 	CreatedAt   time.Time        `json:"createdAt" db:"created_at"`
 	UpdatedAt   time.Time        `json:"updatedAt" db:"updated_at"`
 }
+
+// SkillMatch is a skill found by semantic search, with its cosine distance
+// from the query; 0 is identical.
+type SkillMatch struct {
+	BotSkill
+	// Embedding shadows BotSkill.Embedding, and is never set, to leave the
+	// vector out of search results.
+	Embedding *pgvector.Vector `json:"embedding,omitempty" db:"-"`
+	Distance  float64          `json:"distance" db:"distance"`
+}
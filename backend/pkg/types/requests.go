@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type SignupRequest struct {
 	Email string `json:"email" binding:"required,email"`
 	// #nosec G117
@@ -46,20 +48,48 @@ type CreateBotSpaceRequest struct {
 }
 
 type UpdateBotSpaceRequest struct {
-	Name        *string `json:"name"`
-	Description *string `json:"description"`
+	Name               *string `json:"name"`
+	Description        *string `json:"description"`
+	RequiresTaskReview *bool   `json:"requiresTaskReview"`
 }
 
 type PostMessageRequest struct {
 	Content string `json:"content" binding:"required"`
 }
 
+type AckMessageRequest struct {
+	MessageID string `json:"messageId"`
+}
+
+type MessageAck struct {
+	MessageID  string `json:"messageId"`
+	SenderID   string `json:"senderId"`
+	SenderType string `json:"senderType"`
+}
+
+type TypingIndicator struct {
+	SenderID   string `json:"senderId"`
+	SenderName string `json:"senderName"`
+	SenderType string `json:"senderType"`
+}
+
 type MessageListResponse struct {
 	Messages []Message `json:"messages"`
 	Count    int       `json:"count"`
 	HasMore  bool      `json:"hasMore"`
 }
 
+type MessageSearchResponse struct {
+	Messages []MessageMatch `json:"messages"`
+	Count    int            `json:"count"`
+	HasMore  bool           `json:"hasMore"`
+}
+
+type MessageRecallResponse struct {
+	Messages []RecalledMessage `json:"messages"`
+	Count    int               `json:"count"`
+}
+
 type UpdateBotStatusRequest struct {
 	Status string `json:"status" binding:"required"`
 }
@@ -87,9 +117,95 @@ type JoinBotSpaceRequest struct {
 }
 
 type CreateSpaceTaskRequest struct {
-	Name        string  `json:"name" binding:"required"`
-	Description string  `json:"description" binding:"required"`
-	BotID       *string `json:"botId"`
+	Name         string     `json:"name" binding:"required"`
+	Description  string     `json:"description" binding:"required"`
+	BotID        *string    `json:"botId"`
+	DependsOn    []string   `json:"dependsOn" binding:"max=50,dive,uuid"`
+	ParentTaskID *string    `json:"parentTaskId" binding:"omitempty,uuid"`
+	Priority     int        `json:"priority" binding:"min=-1000,max=1000"`
+	DueAt        *time.Time `json:"dueAt"`
+	// RequiresReview defaults to the space's RequiresTaskReview.
+	RequiresReview *bool `json:"requiresReview"`
+	// RequiredSkills are matched against the names and tags of bot skills.
+	RequiredSkills []string `json:"requiredSkills" binding:"max=20,dive,required,max=100"`
+	// AutoAssign hands a task without a BotID to the best ranked idle bot
+	// instead of only suggesting bots.
+	AutoAssign bool `json:"autoAssign"`
+}
+
+// TaskSuggestion is a bot ranked for a task by skill match, then load.
+type TaskSuggestion struct {
+	BotID         string   `json:"botId"`
+	BotName       string   `json:"botName"`
+	MatchedSkills []string `json:"matchedSkills"`
+	Score         int      `json:"score"`
+	Load          int      `json:"load"`
+	Presence      string   `json:"presence"`
+}
+
+// CreateTaskResponse is the created task with the bots suggested for it,
+// best first. Suggestions are only made for tasks created without a bot
+// which require skills or ask to be auto-assigned.
+type CreateTaskResponse struct {
+	SpaceTask
+	Suggestions []TaskSuggestion `json:"suggestions,omitempty"`
+}
+
+// TaskFilter narrows a task listing. Nil fields match every task.
+type TaskFilter struct {
+	Status       *string
+	BotID        *string
+	ParentTaskID *string
+}
+
+type CreateTaskScheduleRequest struct {
+	Name        string `json:"name" binding:"required,max=200"`
+	Description string `json:"description" binding:"required"`
+	// Cron is a standard five field cron expression or a descriptor such
+	// as @daily, evaluated in Timezone (UTC by default).
+	Cron     string `json:"cron" binding:"required"`
+	Timezone string `json:"timezone"`
+	Priority int    `json:"priority" binding:"min=-1000,max=1000"`
+	// RequiresReview defaults to the space's RequiresTaskReview.
+	RequiresReview *bool `json:"requiresReview"`
+}
+
+type ProposeSubtaskRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description" binding:"required"`
+}
+
+type CompleteTaskRequest struct {
+	Result      string   `json:"result" binding:"max=20000"`
+	ArtifactIDs []string `json:"artifactIds" binding:"max=20,dive,uuid"`
+}
+
+type BlockTaskRequest struct {
+	Reason      string   `json:"reason" binding:"max=5000"`
+	ArtifactIDs []string `json:"artifactIds" binding:"max=20,dive,uuid"`
+}
+
+type TaskHistoryResponse struct {
+	Events []TaskEvent `json:"events"`
+	Count  int         `json:"count"`
+}
+
+type ReviewTaskRequest struct {
+	Feedback string `json:"feedback" binding:"max=5000"`
+}
+
+type TaskNoteRequest struct {
+	Note string `json:"note" binding:"max=2000"`
+}
+
+type ReassignTaskRequest struct {
+	BotID string `json:"botId" binding:"required,uuid"`
+	Note  string `json:"note" binding:"max=2000"`
+}
+
+type HandoffTaskRequest struct {
+	BotID string `json:"botId" binding:"required,uuid"`
+	Note  string `json:"note" binding:"required,max=2000"`
 }
 
 type AssignTaskRequest struct {
@@ -102,6 +218,12 @@ type ArtifactListResponse struct {
 	HasMore   bool       `json:"hasMore"`
 }
 
+type ArtifactSearchResponse struct {
+	Artifacts []ArtifactMatch `json:"artifacts"`
+	Count     int             `json:"count"`
+	HasMore   bool            `json:"hasMore"`
+}
+
 type CreateArtifactRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description" binding:"required"`
@@ -119,3 +241,7 @@ type UpdateBotSkillRequest struct {
 	Description *string  `json:"description"`
 	Tags        []string `json:"tags"`
 }
+
+type RevokeTokensResponse struct {
+	Revoked int64 `json:"revoked"`
+}
@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+)
+
+const (
+	BroadcastBackendMemory   = "memory"
+	BroadcastBackendPostgres = "postgres"
+)
+
+// DeliverFunc hands a published event to the local hub for fan-out.
+type DeliverFunc func(ev Event)
+
+// Broadcaster carries hub events between API replicas. Publish stamps the
+// event with the next sequence number for its space, and every event must
+// reach the DeliverFunc of every subscribed hub exactly once, including the
+// hub on the publishing node.
+type Broadcaster interface {
+	Publish(ctx context.Context, ev Event) error
+	Subscribe(deliver DeliverFunc) error
+	Close() error
+}
+
+// NewBroadcaster returns the backend selected by conf.BroadcastBackend.
+func NewBroadcaster(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Broadcaster, error) {
+	switch conf.BroadcastBackend {
+	case "", BroadcastBackendMemory:
+		return NewMemoryBroadcaster(), nil
+	case BroadcastBackendPostgres:
+		return NewPGBroadcaster(ctx, conf, sdb)
+	default:
+		return nil, fmt.Errorf("unknown broadcast backend %q", conf.BroadcastBackend)
+	}
+}
+
+type memoryBroadcaster struct {
+	mu       sync.Mutex
+	seqs     map[string]int64
+	delivers []DeliverFunc
+}
+
+// NewMemoryBroadcaster returns a single-process broadcaster which delivers
+// events straight back to the local hub.
+func NewMemoryBroadcaster() Broadcaster {
+	return &memoryBroadcaster{seqs: make(map[string]int64)}
+}
+
+// Publish stamps ev under the lock but delivers it outside, so a slow
+// delivery does not hold up publishers to other spaces.
+func (m *memoryBroadcaster) Publish(_ context.Context, ev Event) error {
+	m.mu.Lock()
+	m.seqs[ev.SpaceID]++
+	ev.Seq = m.seqs[ev.SpaceID]
+	delivers := m.delivers
+	m.mu.Unlock()
+
+	for _, deliver := range delivers {
+		deliver(ev)
+	}
+	return nil
+}
+
+func (m *memoryBroadcaster) Subscribe(deliver DeliverFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Copy on write, so Publish can range over a slice it took under the lock.
+	m.delivers = append(m.delivers[:len(m.delivers):len(m.delivers)], deliver)
+	return nil
+}
+
+func (m *memoryBroadcaster) Close() error {
+	return nil
+}
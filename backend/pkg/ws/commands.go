@@ -0,0 +1,101 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	CommandPostMessage = "post_message"
+	CommandAck         = "ack"
+	CommandTyping      = "typing"
+	CommandPing        = "ping"
+
+	ReplyResult = "result"
+	ReplyError  = "error"
+
+	commandTimeout = 10 * time.Second
+)
+
+// CodeAccessRevoked is returned when the principal behind a socket has lost
+// access since it connected. The reply is the last frame the client gets
+// before the socket is closed.
+const CodeAccessRevoked = "access_revoked"
+
+// Command is a frame sent by a client over its socket. ID is chosen by the
+// client and echoed on the reply.
+type Command struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Reply answers a single Command.
+type Reply struct {
+	ID    string        `json:"id"`
+	Type  string        `json:"type"`
+	Data  any           `json:"data,omitempty"`
+	Error *CommandError `json:"error,omitempty"`
+}
+
+// CommandError is a structured failure returned to the client. Status mirrors
+// the HTTP status the equivalent REST call would have returned.
+type CommandError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+// CommandHandler executes a command for a client and returns the reply data.
+// Returning a *CommandError sends it to the client as is; any other error is
+// reported as an internal error.
+type CommandHandler func(ctx context.Context, cmd Command) (any, error)
+
+func (c *Client) handleFrame(frame []byte) {
+	var cmd Command
+	if err := json.Unmarshal(frame, &cmd); err != nil || cmd.Type == "" {
+		c.reply(Reply{Type: ReplyError, Error: &CommandError{Status: 400, Code: "bad_frame", Message: "frames must be JSON commands with a type"}})
+		return
+	}
+
+	if cmd.Type == CommandPing {
+		c.reply(Reply{ID: cmd.ID, Type: ReplyResult, Data: map[string]string{"pong": time.Now().UTC().Format(time.RFC3339Nano)}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	data, err := c.Commands(ctx, cmd)
+	if err != nil {
+		cmdErr, ok := err.(*CommandError)
+		if !ok {
+			c.hub.log.WithError(err).WithField("command", cmd.Type).Error("failed to handle command")
+			cmdErr = &CommandError{Status: 500, Code: "internal", Message: "failed to handle command"}
+		}
+		c.reply(Reply{ID: cmd.ID, Type: ReplyError, Error: cmdErr})
+		if cmdErr.Code == CodeAccessRevoked {
+			// Unregistering closes Send, so WritePump flushes the reply and
+			// then closes the socket.
+			c.hub.Unregister(c)
+		}
+		return
+	}
+	c.reply(Reply{ID: cmd.ID, Type: ReplyResult, Data: data})
+}
+
+func (c *Client) reply(r Reply) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		c.hub.log.WithError(err).Error("failed to marshal command reply")
+		return
+	}
+	if !c.hub.trySend(c, data) {
+		c.hub.log.WithField("command", r.ID).Debug("dropped command reply")
+	}
+}
@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// EventVersion is bumped whenever the envelope shape changes incompatibly.
+const EventVersion = 1
+
+const (
+	EventMessageCreated  = "message.created"
+	EventMessageAcked    = "message.acked"
+	EventTyping          = "typing"
+	EventTaskCreated     = "task.created"
+	EventTaskAssigned    = "task.assigned"
+	EventTaskAccepted    = "task.accepted"
+	EventTaskCompleted   = "task.completed"
+	EventTaskBlocked     = "task.blocked"
+	EventTaskUnlocked    = "task.unlocked"
+	EventTaskProposed    = "task.proposed"
+	EventTaskApproved    = "task.approved"
+	EventTaskRejected    = "task.rejected"
+	EventTaskReleased    = "task.released"
+	EventTaskSubmitted   = "task.submitted"
+	EventTaskUnblocked   = "task.unblocked"
+	EventTaskCancelled   = "task.cancelled"
+	EventTaskReassigned  = "task.reassigned"
+	EventTaskHandedOff   = "task.handed_off"
+	EventStatusUpdated   = "status.updated"
+	EventSummaryUpdated  = "summary.updated"
+	EventArtifactCreated = "artifact.created"
+	EventArtifactDeleted = "artifact.deleted"
+	EventSkillCreated    = "skill.created"
+	EventSkillUpdated    = "skill.updated"
+	EventSkillDeleted    = "skill.deleted"
+	EventScheduleCreated = "schedule.created"
+	EventScheduleUpdated = "schedule.updated"
+	EventScheduleDeleted = "schedule.deleted"
+	EventBotJoined       = "bot.joined"
+	EventBotUpdated      = "bot.updated"
+	EventBotRemoved      = "bot.removed"
+	EventSpaceUpdated    = "space.updated"
+	EventMemberJoined    = "member.joined"
+	EventMemberRemoved   = "member.removed"
+)
+
+// Outcomes of reviewing a task in review.
+const (
+	EventTaskReviewApproved   = "task.review_approved"
+	EventTaskChangesRequested = "task.changes_requested"
+)
+
+// Event is the envelope pushed to subscribers which opt in to typed events.
+// Seq increases by one for every event published to a space; events replayed
+// from history on reconnect carry Replayed and no sequence number.
+type Event struct {
+	Version  int             `json:"version"`
+	Type     string          `json:"type"`
+	SpaceID  string          `json:"spaceId"`
+	Seq      int64           `json:"seq"`
+	Replayed bool            `json:"replayed,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func NewEvent(botSpaceID string, eventType string, payload any) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		Version: EventVersion,
+		Type:    eventType,
+		SpaceID: botSpaceID,
+		Data:    data,
+	}, nil
+}
+
+// EventFilter selects which event types a client receives. A nil filter is a
+// legacy subscriber which only receives bare message.created payloads.
+type EventFilter []string
+
+// ParseEventFilter parses a comma separated list of event types. Entries may
+// be exact types, a "task.*" style prefix, or "*" for everything.
+func ParseEventFilter(raw string) EventFilter {
+	if raw == "" {
+		return nil
+	}
+	filter := EventFilter{}
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			filter = append(filter, part)
+		}
+	}
+	return filter
+}
+
+func (f EventFilter) Matches(eventType string) bool {
+	for _, pattern := range f {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -9,66 +11,144 @@ import (
 )
 
 const (
-	writeWait  = 10 * time.Second
-	pongWait   = 60 * time.Second
-	pingPeriod = 30 * time.Second
+	writeWait        = 10 * time.Second
+	pongWait         = 60 * time.Second
+	pingPeriod       = 30 * time.Second
+	defaultReadLimit = 512
 )
 
 type Client struct {
 	Conn       *websocket.Conn
 	Send       chan []byte
 	BotSpaceID string
-	hub        *Hub
+	// BotID is set when the subscriber is a bot, so its connection counts
+	// towards its presence.
+	BotID  string
+	Events EventFilter
+	// Commands handles frames sent by the client. Without it inbound frames
+	// are discarded and reads are capped at defaultReadLimit.
+	Commands  CommandHandler
+	ReadLimit int64
+	hub       *Hub
+	replay    replayState
+	lag       lagState
 }
 
 type Hub struct {
-	mu    sync.RWMutex
-	rooms map[string]map[*Client]struct{}
-	log   logrus.Ext1FieldLogger
+	mu                 sync.RWMutex
+	rooms              map[string]map[*Client]struct{}
+	log                logrus.Ext1FieldLogger
+	broadcaster        Broadcaster
+	slowConsumerPolicy string
+	presence           *Presence
 }
 
-func NewHub(log logrus.Ext1FieldLogger) *Hub {
-	return &Hub{
-		rooms: make(map[string]map[*Client]struct{}),
-		log:   log,
+func NewHub(log logrus.Ext1FieldLogger, broadcaster Broadcaster, slowConsumerPolicy string) (*Hub, error) {
+	if err := validSlowConsumerPolicy(slowConsumerPolicy); err != nil {
+		return nil, err
 	}
+	h := &Hub{
+		rooms:              make(map[string]map[*Client]struct{}),
+		log:                log,
+		broadcaster:        broadcaster,
+		slowConsumerPolicy: slowConsumerPolicy,
+	}
+	if err := broadcaster.Subscribe(h.deliver); err != nil {
+		return nil, err
+	}
+	return h, nil
 }
 
-func (h *Hub) NewClient(conn *websocket.Conn, botSpaceID string) *Client {
+func (h *Hub) NewClient(conn *websocket.Conn, botSpaceID string, events EventFilter) *Client {
 	return &Client{
 		Conn:       conn,
 		Send:       make(chan []byte, 256),
 		BotSpaceID: botSpaceID,
+		Events:     events,
 		hub:        h,
 	}
 }
 
+// NewSubscriber returns a client without a socket, for transports such as
+// SSE and long polling which read Send themselves.
+func (h *Hub) NewSubscriber(botSpaceID string, events EventFilter) *Client {
+	return h.NewClient(nil, botSpaceID, events)
+}
+
 func (h *Hub) Register(client *Client) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 	if h.rooms[client.BotSpaceID] == nil {
 		h.rooms[client.BotSpaceID] = make(map[*Client]struct{})
 	}
 	h.rooms[client.BotSpaceID][client] = struct{}{}
+	h.mu.Unlock()
+
+	// Presence publishes through the hub, so it must run without h.mu held.
+	if h.presence != nil && client.BotID != "" {
+		h.presence.connected(client.BotSpaceID, client.BotID)
+	}
 }
 
 func (h *Hub) Unregister(client *Client) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	removed := false
 	if clients, ok := h.rooms[client.BotSpaceID]; ok {
 		if _, exists := clients[client]; exists {
 			delete(clients, client)
 			close(client.Send)
+			removed = true
 			if len(clients) == 0 {
 				delete(h.rooms, client.BotSpaceID)
 			}
 		}
 	}
+	h.mu.Unlock()
+
+	if removed && h.presence != nil && client.BotID != "" {
+		h.presence.disconnected(client.BotID)
+	}
 }
 
-func (h *Hub) Broadcast(botSpaceID string, data []byte) {
+// Publish sends a typed event to every client subscribed to botSpaceID on any
+// replica sharing this hub's broadcaster.
+func (h *Hub) Publish(botSpaceID string, eventType string, payload any) {
+	ev, err := NewEvent(botSpaceID, eventType, payload)
+	if err != nil {
+		h.log.WithError(err).WithField("type", eventType).Error("failed to marshal event payload")
+		return
+	}
+	if err := h.broadcaster.Publish(context.Background(), ev); err != nil {
+		h.log.WithError(err).WithField("botSpaceID", botSpaceID).Error("failed to publish event")
+	}
+}
+
+// trySend queues data for client unless it has been unregistered or its
+// buffer is full.
+func (h *Hub) trySend(client *Client, data []byte) bool {
+	_, queued := h.send(client, data)
+	return queued
+}
+
+// send queues data for client without blocking. It reports whether the
+// client is still registered and, if so, whether data fit in its buffer.
+// Holding h.mu stops Unregister from closing Send during the send.
+func (h *Hub) send(client *Client, data []byte) (registered, queued bool) {
 	h.mu.RLock()
-	roomClients, ok := h.rooms[botSpaceID]
+	defer h.mu.RUnlock()
+	if _, ok := h.rooms[client.BotSpaceID][client]; !ok {
+		return false, false
+	}
+	select {
+	case client.Send <- data:
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+func (h *Hub) deliver(ev Event) {
+	h.mu.RLock()
+	roomClients, ok := h.rooms[ev.SpaceID]
 	if !ok || len(roomClients) == 0 {
 		h.mu.RUnlock()
 		return
@@ -79,13 +159,72 @@ func (h *Hub) Broadcast(botSpaceID string, data []byte) {
 	}
 	h.mu.RUnlock()
 
+	messageID := eventMessageID(ev)
+	var envelope []byte
 	for _, client := range clients {
-		select {
-		case client.Send <- data:
-		default:
-			go h.Unregister(client)
+		if !client.wants(ev.Type) || client.park(ev) {
+			continue
+		}
+
+		skipped, notice := client.checkLag()
+		if skipped {
+			droppedEvents.WithLabelValues(client.BotSpaceID).Inc()
+			continue
 		}
+		if notice != nil {
+			h.trySend(client, notice)
+		}
+
+		// Legacy subscribers get the bare message JSON.
+		data := []byte(ev.Data)
+		if client.Events != nil {
+			if envelope == nil {
+				var err error
+				if envelope, err = json.Marshal(ev); err != nil {
+					h.log.WithError(err).WithField("type", ev.Type).Error("failed to marshal event")
+					continue
+				}
+			}
+			data = envelope
+		}
+
+		// Clients unregistered since the snapshot was taken are skipped.
+		registered, queued := h.send(client, data)
+		if queued {
+			client.queued(messageID)
+		} else if registered {
+			h.overflow(client)
+		}
+	}
+}
+
+// eventMessageID returns the message id carried by a message.created event.
+func eventMessageID(ev Event) string {
+	if ev.Type != EventMessageCreated {
+		return ""
+	}
+	var msg struct {
+		ID string `json:"id"`
 	}
+	if err := json.Unmarshal(ev.Data, &msg); err != nil {
+		return ""
+	}
+	return msg.ID
+}
+
+func (c *Client) wants(eventType string) bool {
+	if c.Events == nil {
+		return eventType == EventMessageCreated
+	}
+	return c.Events.Matches(eventType)
+}
+
+// Encode renders ev the way this client expects to receive it.
+func (c *Client) Encode(ev Event) ([]byte, error) {
+	if c.Events == nil {
+		return ev.Data, nil
+	}
+	return json.Marshal(ev)
 }
 
 func (c *Client) WritePump() {
@@ -108,6 +247,13 @@ func (c *Client) WritePump() {
 			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				return
 			}
+			if len(c.Send) == 0 {
+				if notice := c.TakeResyncNotice(); notice != nil {
+					if err := c.Conn.WriteMessage(websocket.TextMessage, notice); err != nil {
+						return
+					}
+				}
+			}
 		case <-ticker.C:
 			if err := c.Conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 				return
@@ -126,7 +272,11 @@ func (c *Client) ReadPump() {
 			c.hub.log.WithError(err).Debug("failed to close connection")
 		}
 	}()
-	c.Conn.SetReadLimit(512)
+	readLimit := c.ReadLimit
+	if c.Commands == nil || readLimit <= 0 {
+		readLimit = defaultReadLimit
+	}
+	c.Conn.SetReadLimit(readLimit)
 	if err := c.Conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
 		c.hub.log.WithError(err).Debug("failed to set read deadline")
 		return
@@ -135,8 +285,12 @@ func (c *Client) ReadPump() {
 		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	})
 	for {
-		if _, _, err := c.Conn.ReadMessage(); err != nil {
+		msgType, frame, err := c.Conn.ReadMessage()
+		if err != nil {
 			break
 		}
+		if c.Commands != nil && msgType == websocket.TextMessage {
+			c.handleFrame(frame)
+		}
 	}
 }
@@ -0,0 +1,225 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/numbergroup/claw-swarm/pkg/config"
+	"github.com/numbergroup/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	pgBroadcastChannel = "claw_swarm_broadcast"
+	// Postgres rejects NOTIFY payloads of 8000 bytes or more, so anything
+	// bigger is parked in broadcast_payloads and sent by reference.
+	maxNotifyPayload     = 7900
+	payloadRetention     = 5 * time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+type pgNotification struct {
+	Origin    string `json:"o"`
+	Event     *Event `json:"e,omitempty"`
+	PayloadID string `json:"r,omitempty"`
+}
+
+type pgBroadcaster struct {
+	db            *sqlx.DB
+	log           logrus.Ext1FieldLogger
+	connStr       string
+	nodeID        string
+	deliver       DeliverFunc
+	listener      *pq.Listener
+	nextSeq       *sqlx.Stmt
+	notify        *sqlx.Stmt
+	insertPayload *sqlx.Stmt
+	getPayload    *sqlx.Stmt
+	prunePayloads *sqlx.Stmt
+	done          chan struct{}
+}
+
+// NewPGBroadcaster returns a broadcaster which fans events out to every API
+// replica through Postgres LISTEN/NOTIFY. Events are delivered to the local
+// hub directly and skipped when they loop back from the database. Sequence
+// numbers come from space_event_seqs so they agree across replicas.
+func NewPGBroadcaster(ctx context.Context, conf *config.Config, sdb *sqlx.DB) (Broadcaster, error) {
+	connStr, err := conf.PSQLConnString()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build listener connection string")
+	}
+
+	nextSeq, err := sdb.PreparexContext(ctx,
+		`INSERT INTO space_event_seqs (bot_space_id, seq) VALUES ($1, 1)
+		ON CONFLICT (bot_space_id) DO UPDATE SET seq = space_event_seqs.seq + 1
+		RETURNING seq`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare nextSeq statement")
+	}
+
+	notify, err := sdb.PreparexContext(ctx, `SELECT pg_notify($1, $2)`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare notify statement")
+	}
+
+	insertPayload, err := sdb.PreparexContext(ctx,
+		`INSERT INTO broadcast_payloads (id, bot_space_id, data) VALUES ($1, $2, $3)`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare insertPayload statement")
+	}
+
+	getPayload, err := sdb.PreparexContext(ctx,
+		`SELECT data FROM broadcast_payloads WHERE id = $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare getPayload statement")
+	}
+
+	prunePayloads, err := sdb.PreparexContext(ctx,
+		`DELETE FROM broadcast_payloads WHERE created_at < $1`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare prunePayloads statement")
+	}
+
+	return &pgBroadcaster{
+		db:            sdb,
+		log:           conf.GetLogger(),
+		connStr:       connStr,
+		nodeID:        uuid.New().String(),
+		nextSeq:       nextSeq,
+		notify:        notify,
+		insertPayload: insertPayload,
+		getPayload:    getPayload,
+		prunePayloads: prunePayloads,
+		done:          make(chan struct{}),
+	}, nil
+}
+
+func (p *pgBroadcaster) Publish(ctx context.Context, ev Event) error {
+	if err := p.nextSeq.GetContext(ctx, &ev.Seq, ev.SpaceID); err != nil {
+		return errors.Wrap(err, "failed to get next event sequence")
+	}
+
+	if p.deliver != nil {
+		p.deliver(ev)
+	}
+
+	n := pgNotification{
+		Origin: p.nodeID,
+		Event:  &ev,
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal notification")
+	}
+
+	if len(payload) > maxNotifyPayload {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal event")
+		}
+		n.Event = nil
+		n.PayloadID = uuid.New().String()
+		if _, err := p.insertPayload.ExecContext(ctx, n.PayloadID, ev.SpaceID, string(data)); err != nil {
+			return errors.Wrap(err, "failed to store broadcast payload")
+		}
+		payload, err = json.Marshal(n)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal notification")
+		}
+	}
+
+	if _, err := p.notify.ExecContext(ctx, pgBroadcastChannel, string(payload)); err != nil {
+		return errors.Wrap(err, "failed to notify")
+	}
+	return nil
+}
+
+func (p *pgBroadcaster) Subscribe(deliver DeliverFunc) error {
+	p.deliver = deliver
+
+	p.listener = pq.NewListener(p.connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			p.log.WithError(err).WithField("event", ev).Warn("broadcast listener event")
+		}
+	})
+	if err := p.listener.Listen(pgBroadcastChannel); err != nil {
+		return errors.Wrap(err, "failed to listen on broadcast channel")
+	}
+
+	go p.run()
+	return nil
+}
+
+func (p *pgBroadcaster) Close() error {
+	close(p.done)
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
+
+func (p *pgBroadcaster) run() {
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+	prune := time.NewTicker(payloadRetention)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification means the listener reconnected; anything
+			// published while it was down is gone.
+			if n != nil {
+				p.handle(n.Extra)
+			}
+		case <-ping.C:
+			go func() {
+				if err := p.listener.Ping(); err != nil {
+					p.log.WithError(err).Debug("broadcast listener ping failed")
+				}
+			}()
+		case <-prune.C:
+			if _, err := p.prunePayloads.Exec(time.Now().Add(-payloadRetention)); err != nil {
+				p.log.WithError(err).Error("failed to prune broadcast payloads")
+			}
+		}
+	}
+}
+
+func (p *pgBroadcaster) handle(extra string) {
+	var n pgNotification
+	if err := json.Unmarshal([]byte(extra), &n); err != nil {
+		p.log.WithError(err).Error("failed to unmarshal broadcast notification")
+		return
+	}
+	if n.Origin == p.nodeID {
+		return
+	}
+
+	if n.PayloadID != "" {
+		var stored string
+		if err := p.getPayload.Get(&stored, n.PayloadID); err != nil {
+			p.log.WithError(err).WithField("payloadID", n.PayloadID).Error("failed to load broadcast payload")
+			return
+		}
+		n.Event = &Event{}
+		if err := json.Unmarshal([]byte(stored), n.Event); err != nil {
+			p.log.WithError(err).WithField("payloadID", n.PayloadID).Error("failed to unmarshal broadcast payload")
+			return
+		}
+	}
+	if n.Event == nil {
+		return
+	}
+
+	p.deliver(*n.Event)
+}
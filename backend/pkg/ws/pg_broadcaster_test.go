@@ -0,0 +1,119 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/numbergroup/claw-swarm/pkg/dbtest"
+)
+
+func TestPGBroadcasterAcrossHubs(t *testing.T) {
+	conf, sdb := dbtest.New(t)
+	ctx := context.Background()
+	spaceID := dbtest.Space(t, sdb)
+
+	hubs := make([]*Hub, 2)
+	for i := range hubs {
+		broadcaster, err := NewPGBroadcaster(ctx, conf, sdb)
+		if err != nil {
+			t.Fatalf("failed to create broadcaster: %v", err)
+		}
+		t.Cleanup(func() { broadcaster.Close() })
+		hubs[i], err = NewHub(conf.GetLogger(), broadcaster, SlowConsumerDrop)
+		if err != nil {
+			t.Fatalf("failed to create hub: %v", err)
+		}
+	}
+	hubA, hubB := hubs[0], hubs[1]
+
+	subscriber := hubB.NewSubscriber(spaceID, ParseEventFilter("*"))
+	hubB.Register(subscriber)
+	t.Cleanup(func() { hubB.Unregister(subscriber) })
+
+	// The listener connects in the background, so keep publishing until the
+	// first event makes it across.
+	deadline := time.After(10 * time.Second)
+	for ready := false; !ready; {
+		hubA.Publish(spaceID, EventTyping, map[string]string{"botId": "warmup"})
+		select {
+		case <-subscriber.Send:
+			ready = true
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no event reached hub B")
+		}
+	}
+	// Let any other warm-up events still in flight arrive before discarding.
+	time.Sleep(200 * time.Millisecond)
+	drain(subscriber)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "inline", content: "hello"},
+		{name: "stored payload", content: strings.Repeat("x", 2*maxNotifyPayload)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hubA.Publish(spaceID, EventMessageCreated, map[string]string{"id": tt.name, "content": tt.content})
+
+			ev := receive(t, subscriber)
+			if ev.Type != EventMessageCreated {
+				t.Fatalf("got event type %q, want %q", ev.Type, EventMessageCreated)
+			}
+			var msg map[string]string
+			if err := json.Unmarshal(ev.Data, &msg); err != nil {
+				t.Fatalf("failed to unmarshal event data: %v", err)
+			}
+			if msg["id"] != tt.name || msg["content"] != tt.content {
+				t.Fatalf("got message %q with %d bytes of content, want %q with %d",
+					msg["id"], len(msg["content"]), tt.name, len(tt.content))
+			}
+
+			select {
+			case data := <-subscriber.Send:
+				t.Fatalf("event delivered twice: %s", data)
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+
+	var stored int
+	if err := sdb.Get(&stored, `SELECT count(*) FROM broadcast_payloads WHERE bot_space_id = $1`, spaceID); err != nil {
+		t.Fatalf("failed to count broadcast payloads: %v", err)
+	}
+	if stored != 1 {
+		t.Fatalf("got %d stored payloads, want 1", stored)
+	}
+}
+
+// receive waits for the next event queued for client.
+func receive(t *testing.T, client *Client) Event {
+	t.Helper()
+	select {
+	case data := <-client.Send:
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			t.Fatalf("failed to unmarshal event: %v", err)
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+// drain discards whatever is queued for client.
+func drain(client *Client) {
+	for {
+		select {
+		case <-client.Send:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/numbergroup/claw-swarm/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceIdle    = "idle"
+	PresenceOffline = "offline"
+
+	EventBotPresence = "bot.presence"
+
+	presenceSweepInterval = 15 * time.Second
+)
+
+// LastSeenStore persists bot activity so replicas which do not hold a bot's
+// connection still see it as active.
+type LastSeenStore interface {
+	UpdateLastSeen(ctx context.Context, id string) error
+	ListLastSeen(ctx context.Context, ids []string) (map[string]time.Time, error)
+}
+
+// BotPresence is the payload of bot.presence events.
+type BotPresence struct {
+	BotID      string    `json:"botId"`
+	State      string    `json:"state"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+type presenceEntry struct {
+	botSpaceID string
+	conns      int
+	lastSeen   time.Time
+	lastTouch  time.Time
+	state      string
+}
+
+// Presence derives online, idle and offline state for bots from their open
+// hub connections and heartbeats. A bot with a live connection is online; a
+// bot without one is online until idleAfter has passed since it was last
+// seen, then idle until offlineAfter.
+type Presence struct {
+	mu           sync.Mutex
+	log          logrus.Ext1FieldLogger
+	hub          *Hub
+	store        LastSeenStore
+	idleAfter    time.Duration
+	offlineAfter time.Duration
+	bots         map[string]*presenceEntry
+}
+
+// NewPresence attaches a presence tracker to hub. Run must be started for
+// state changes caused by the passage of time to be published.
+func NewPresence(log logrus.Ext1FieldLogger, hub *Hub, store LastSeenStore, idleAfter, offlineAfter time.Duration) *Presence {
+	p := &Presence{
+		log:          log,
+		hub:          hub,
+		store:        store,
+		idleAfter:    idleAfter,
+		offlineAfter: offlineAfter,
+		bots:         make(map[string]*presenceEntry),
+	}
+	hub.presence = p
+	return p
+}
+
+func (p *Presence) entry(botSpaceID, botID string) *presenceEntry {
+	e, ok := p.bots[botID]
+	if !ok {
+		e = &presenceEntry{botSpaceID: botSpaceID, state: PresenceOffline}
+		p.bots[botID] = e
+	}
+	return e
+}
+
+func (p *Presence) stateOf(e *presenceEntry, lastSeen time.Time, now time.Time) string {
+	if e != nil && e.conns > 0 {
+		return PresenceOnline
+	}
+	if e != nil && e.lastSeen.After(lastSeen) {
+		lastSeen = e.lastSeen
+	}
+	switch since := now.Sub(lastSeen); {
+	case lastSeen.IsZero():
+		return PresenceOffline
+	case since < p.idleAfter:
+		return PresenceOnline
+	case since < p.offlineAfter:
+		return PresenceIdle
+	default:
+		return PresenceOffline
+	}
+}
+
+// update recomputes the state of botID and returns the presence to publish
+// if it changed. Callers must hold p.mu.
+func (p *Presence) update(botID string, e *presenceEntry, now time.Time) (BotPresence, bool) {
+	state := p.stateOf(e, time.Time{}, now)
+	if state == e.state {
+		return BotPresence{}, false
+	}
+	e.state = state
+	return BotPresence{BotID: botID, State: state, LastSeenAt: e.lastSeen}, true
+}
+
+func (p *Presence) publish(botSpaceID string, presence BotPresence) {
+	p.hub.Publish(botSpaceID, EventBotPresence, presence)
+}
+
+func (p *Presence) connected(botSpaceID, botID string) {
+	now := time.Now()
+	p.mu.Lock()
+	e := p.entry(botSpaceID, botID)
+	e.conns++
+	e.lastSeen = now
+	presence, changed := p.update(botID, e, now)
+	p.mu.Unlock()
+
+	if changed {
+		p.publish(botSpaceID, presence)
+	}
+}
+
+func (p *Presence) disconnected(botID string) {
+	now := time.Now()
+	p.mu.Lock()
+	e, ok := p.bots[botID]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	e.conns--
+	e.lastSeen = now
+	presence, changed := p.update(botID, e, now)
+	p.mu.Unlock()
+
+	if changed {
+		p.publish(e.botSpaceID, presence)
+	}
+}
+
+// Seen records activity from botID without persisting it.
+func (p *Presence) Seen(botSpaceID, botID string) {
+	now := time.Now()
+	p.mu.Lock()
+	e := p.entry(botSpaceID, botID)
+	e.lastSeen = now
+	presence, changed := p.update(botID, e, now)
+	p.mu.Unlock()
+
+	if changed {
+		p.publish(botSpaceID, presence)
+	}
+}
+
+// Heartbeat records activity from botID and persists it as the bot's last
+// seen time.
+func (p *Presence) Heartbeat(ctx context.Context, botSpaceID, botID string) (BotPresence, error) {
+	if err := p.store.UpdateLastSeen(ctx, botID); err != nil {
+		return BotPresence{}, err
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	e := p.entry(botSpaceID, botID)
+	e.lastSeen = now
+	e.lastTouch = now
+	presence, changed := p.update(botID, e, now)
+	current := BotPresence{BotID: botID, State: e.state, LastSeenAt: e.lastSeen}
+	p.mu.Unlock()
+
+	if changed {
+		p.publish(botSpaceID, presence)
+	}
+	return current, nil
+}
+
+// State returns the presence state of bot, combining this replica's view
+// with the last seen time persisted by any replica.
+func (p *Presence) State(bot types.Bot) string {
+	var lastSeen time.Time
+	if bot.LastSeenAt != nil {
+		lastSeen = *bot.LastSeenAt
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stateOf(p.bots[bot.ID], lastSeen, time.Now())
+}
+
+// Run periodically publishes state changes caused by bots going quiet and
+// persists last seen times for bots connected to this replica, until ctx is
+// done.
+func (p *Presence) Run(ctx context.Context) {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.sweep(ctx)
+		}
+	}
+}
+
+func (p *Presence) sweep(ctx context.Context) {
+	type change struct {
+		botSpaceID string
+		presence   BotPresence
+	}
+	var changes []change
+	var touch []string
+
+	// A bot which looks quiet here may be connected to another replica, so
+	// check the last seen time that replica persists before declaring it
+	// idle or offline.
+	now := time.Now()
+	var quiet []string
+	p.mu.Lock()
+	for botID, e := range p.bots {
+		if e.conns == 0 && p.stateOf(e, time.Time{}, now) != e.state {
+			quiet = append(quiet, botID)
+		}
+	}
+	p.mu.Unlock()
+
+	var persisted map[string]time.Time
+	unverified := false
+	if len(quiet) > 0 {
+		var err error
+		persisted, err = p.store.ListLastSeen(ctx, quiet)
+		if err != nil {
+			// Leave quiet bots as they are until the next sweep.
+			p.log.WithError(err).Error("failed to list bot last seen times")
+			unverified = true
+		}
+	}
+
+	p.mu.Lock()
+	for botID, e := range p.bots {
+		if e.conns > 0 {
+			e.lastSeen = now
+			// Refresh the persisted time well before other replicas would
+			// consider the bot idle.
+			if now.Sub(e.lastTouch) >= p.idleAfter/2 {
+				e.lastTouch = now
+				touch = append(touch, botID)
+			}
+		} else if unverified {
+			continue
+		} else if seen := persisted[botID]; seen.After(e.lastSeen) {
+			e.lastSeen = seen
+		}
+		if presence, changed := p.update(botID, e, now); changed {
+			changes = append(changes, change{botSpaceID: e.botSpaceID, presence: presence})
+		}
+		if e.state == PresenceOffline && e.conns == 0 {
+			delete(p.bots, botID)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, botID := range touch {
+		if err := p.store.UpdateLastSeen(ctx, botID); err != nil {
+			p.log.WithError(err).WithField("botID", botID).Debug("failed to update bot last seen")
+		}
+	}
+	for _, ch := range changes {
+		p.publish(ch.botSpaceID, ch.presence)
+	}
+}
+
+// Forget drops any presence kept for botID, e.g. once the bot is removed.
+func (p *Presence) Forget(botID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.bots, botID)
+}
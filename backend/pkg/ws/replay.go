@@ -0,0 +1,107 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/numbergroup/errors"
+)
+
+// CloseReplayFailed is sent when a resumed subscription cannot replay from
+// the requested cursor, e.g. because the message was cleaned up. Clients
+// should resync over HTTP and reconnect without a cursor.
+const CloseReplayFailed = 4000
+
+var (
+	ErrReplayOverflow = errors.New("too many live events arrived during replay")
+	ErrReplayAborted  = errors.New("client was unregistered during replay")
+)
+
+// replayState parks live events for a client while missed history is being
+// written to its socket, so nothing is lost between the history query and
+// the switch to live delivery.
+type replayState struct {
+	mu         sync.Mutex
+	active     bool
+	overflowed bool
+	pending    []Event
+}
+
+// BeginReplay must be called before the client is registered with the hub.
+func (c *Client) BeginReplay() {
+	c.replay.mu.Lock()
+	defer c.replay.mu.Unlock()
+	c.replay.active = true
+}
+
+func (c *Client) park(ev Event) bool {
+	c.replay.mu.Lock()
+	defer c.replay.mu.Unlock()
+	if !c.replay.active {
+		return false
+	}
+	if len(c.replay.pending) >= cap(c.Send) {
+		c.replay.overflowed = true
+		return true
+	}
+	c.replay.pending = append(c.replay.pending, ev)
+	return true
+}
+
+// WriteReplay writes ev straight to the socket. It may only be used between
+// BeginReplay and EndReplay, before WritePump has started.
+func (c *Client) WriteReplay(ev Event) error {
+	data, err := c.Encode(ev)
+	if err != nil {
+		return err
+	}
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	return c.Conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// EndReplay queues the live events parked during replay, skipping messages
+// whose ids were already replayed, and switches the client to live delivery.
+// It returns ErrReplayAborted if the client was unregistered in the meantime.
+func (c *Client) EndReplay(replayedIDs map[string]struct{}) error {
+	c.replay.mu.Lock()
+	defer c.replay.mu.Unlock()
+	c.replay.active = false
+	pending := c.replay.pending
+	c.replay.pending = nil
+
+	if c.replay.overflowed {
+		return ErrReplayOverflow
+	}
+
+	for _, ev := range pending {
+		if id := eventMessageID(ev); id != "" {
+			if _, dup := replayedIDs[id]; dup {
+				continue
+			}
+		}
+		data, err := c.Encode(ev)
+		if err != nil {
+			return err
+		}
+		// Live events were parked, so Send is empty and has room for all of
+		// pending; a failed send means the client is gone.
+		if !c.hub.trySend(c, data) {
+			return ErrReplayAborted
+		}
+	}
+	return nil
+}
+
+// CloseWithReason sends a close frame before WritePump has started.
+func (c *Client) CloseWithReason(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait)); err != nil {
+		c.hub.log.WithError(err).Debug("failed to write close message")
+	}
+	if err := c.Conn.Close(); err != nil {
+		c.hub.log.WithError(err).Debug("failed to close connection")
+	}
+}
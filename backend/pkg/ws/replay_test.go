@@ -0,0 +1,200 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testSpaceID = "space"
+
+// messageLog stands in for the messages table. Like PostMessage, post stores
+// a message before publishing it.
+type messageLog struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (l *messageLog) post(h *Hub, id string) {
+	l.mu.Lock()
+	l.ids = append(l.ids, id)
+	l.mu.Unlock()
+	h.Publish(testSpaceID, EventMessageCreated, map[string]string{"id": id})
+}
+
+// since returns the ids stored after id, or all of them if id is empty.
+func (l *messageLog) since(id string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.ids[slices.Index(l.ids, id)+1:])
+}
+
+// replay mimics the replay handlers: it reads history after since while
+// live events are parked, then hands the client over to live delivery.
+func (l *messageLog) replay(client *Client, since string) ([]string, error) {
+	history := l.since(since)
+	replayed := make(map[string]struct{}, len(history))
+	for _, id := range history {
+		replayed[id] = struct{}{}
+	}
+	return history, client.EndReplay(replayed)
+}
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h, err := NewHub(logrus.New(), NewMemoryBroadcaster(), SlowConsumerDrop)
+	if err != nil {
+		t.Fatalf("failed to create hub: %v", err)
+	}
+	return h
+}
+
+// messageID returns the id of a message queued for a legacy subscriber.
+func messageID(t *testing.T, data []byte) string {
+	t.Helper()
+	var msg struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Errorf("failed to unmarshal message: %v", err)
+	}
+	return msg.ID
+}
+
+// queuedIDs returns the ids of the messages queued for client, reading until
+// Send is closed or, if wait is false, until it is empty.
+func queuedIDs(t *testing.T, client *Client, wait bool) []string {
+	t.Helper()
+	var ids []string
+	for {
+		var data []byte
+		var ok bool
+		if wait {
+			data, ok = <-client.Send
+		} else {
+			select {
+			case data, ok = <-client.Send:
+			default:
+			}
+		}
+		if !ok {
+			return ids
+		}
+		ids = append(ids, messageID(t, data))
+	}
+}
+
+func TestEndReplaySkipsReplayedMessages(t *testing.T) {
+	h := newTestHub(t)
+	log := &messageLog{}
+	log.post(h, "m1")
+
+	client := h.NewSubscriber(testSpaceID, nil)
+	client.BeginReplay()
+	h.Register(client)
+
+	// m2 is both parked and read back as history; m3 arrives after the
+	// history query and is only parked.
+	log.post(h, "m2")
+	history := log.since("m1")
+	log.post(h, "m3")
+
+	replayed := map[string]struct{}{}
+	for _, id := range history {
+		replayed[id] = struct{}{}
+	}
+	if err := client.EndReplay(replayed); err != nil {
+		t.Fatalf("EndReplay failed: %v", err)
+	}
+	log.post(h, "m4")
+
+	got := append(history, queuedIDs(t, client, false)...)
+	want := []string{"m2", "m3", "m4"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got messages %v, want %v", got, want)
+	}
+}
+
+func TestEndReplayAfterUnregister(t *testing.T) {
+	h := newTestHub(t)
+	log := &messageLog{}
+
+	client := h.NewSubscriber(testSpaceID, nil)
+	client.BeginReplay()
+	h.Register(client)
+	log.post(h, "m1")
+	h.Unregister(client)
+
+	if err := client.EndReplay(nil); err != ErrReplayAborted {
+		t.Fatalf("got error %v, want ErrReplayAborted", err)
+	}
+}
+
+// TestReplayAcrossDisconnect drops a subscriber in the middle of a stream of
+// messages and resumes from the last message it received while the stream
+// continues. Between them the two connections must see every message once,
+// in order.
+func TestReplayAcrossDisconnect(t *testing.T) {
+	const total = 500
+	h := newTestHub(t)
+	log := &messageLog{}
+
+	first := h.NewSubscriber(testSpaceID, nil)
+	h.Register(first)
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := range total {
+			log.post(h, fmt.Sprintf("m%03d", i))
+			time.Sleep(10 * time.Microsecond)
+		}
+	}()
+
+	// Disconnect after a few messages, keeping whatever was already queued
+	// since a socket flushes its buffer before closing.
+	var received []string
+	for len(received) < 50 {
+		received = append(received, messageID(t, <-first.Send))
+	}
+	h.Unregister(first)
+	received = append(received, queuedIDs(t, first, true)...)
+
+	second := h.NewSubscriber(testSpaceID, nil)
+	second.BeginReplay()
+	h.Register(second)
+	history, err := log.replay(second, received[len(received)-1])
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	received = append(received, history...)
+
+	live := make(chan []string)
+	go func() { live <- queuedIDs(t, second, true) }()
+	<-published
+	h.Unregister(second)
+	received = append(received, <-live...)
+
+	want := log.since("")
+	if len(want) != total {
+		t.Fatalf("published %d messages, want %d", len(want), total)
+	}
+	if !slices.Equal(received, want) {
+		t.Fatalf("got %d messages, want %d in order; first difference at %d",
+			len(received), total, firstDifference(received, want))
+	}
+}
+
+func firstDifference(a, b []string) int {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
@@ -0,0 +1,174 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Slow consumer policies decide what happens when a client's Send buffer is
+// full at delivery time.
+const (
+	// SlowConsumerDrop unregisters the client without telling it why.
+	SlowConsumerDrop = "drop"
+	// SlowConsumerDisconnect closes the socket with CloseSlowConsumer.
+	SlowConsumerDisconnect = "disconnect"
+	// SlowConsumerCoalesce skips events until the buffer drains, then sends a
+	// single resync notice. Legacy subscribers cannot parse the notice and are
+	// disconnected instead.
+	SlowConsumerCoalesce = "coalesce"
+)
+
+const (
+	CloseSlowConsumer = 4001
+	EventResync       = "resync"
+)
+
+var (
+	slowConsumerEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clawswarm_hub_slow_consumer_total",
+		Help: "Times a subscriber's send buffer was full, by space and policy applied.",
+	}, []string{"bot_space_id", "policy"})
+	droppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "clawswarm_hub_dropped_events_total",
+		Help: "Events not delivered to a subscriber because it was too slow, by space.",
+	}, []string{"bot_space_id"})
+)
+
+func init() {
+	prometheus.MustRegister(slowConsumerEvents, droppedEvents)
+}
+
+// ResyncNotice tells a coalesced client how many events it missed and which
+// message id to resume from over HTTP.
+type ResyncNotice struct {
+	Missed     int    `json:"missed"`
+	ResyncFrom string `json:"resyncFrom,omitempty"`
+}
+
+type lagState struct {
+	mu            sync.Mutex
+	lastMessageID string
+	lagging       bool
+	missed        int
+	resyncFrom    string
+	dropReason    string
+}
+
+func validSlowConsumerPolicy(policy string) error {
+	switch policy {
+	case SlowConsumerDrop, SlowConsumerDisconnect, SlowConsumerCoalesce:
+		return nil
+	default:
+		return fmt.Errorf("unknown slow consumer policy %q", policy)
+	}
+}
+
+// queued records that an event reached the client's buffer.
+func (c *Client) queued(messageID string) {
+	if messageID == "" {
+		return
+	}
+	c.lag.mu.Lock()
+	defer c.lag.mu.Unlock()
+	c.lag.lastMessageID = messageID
+}
+
+// checkLag is called before queueing an event. A lagging client with room in
+// its buffer again gets its resync notice; one without is skipped and the
+// miss counted.
+func (c *Client) checkLag() (skipped bool, notice []byte) {
+	c.lag.mu.Lock()
+	lagging := c.lag.lagging
+	c.lag.mu.Unlock()
+	if !lagging {
+		return false, nil
+	}
+
+	// Leave room for both the notice and the event being delivered.
+	if len(c.Send) < cap(c.Send)-1 {
+		return false, c.TakeResyncNotice()
+	}
+
+	c.lag.mu.Lock()
+	defer c.lag.mu.Unlock()
+	c.lag.missed++
+	return true, nil
+}
+
+func (c *Client) startLagging() {
+	c.lag.mu.Lock()
+	defer c.lag.mu.Unlock()
+	c.lag.lagging = true
+	c.lag.missed = 1
+	c.lag.resyncFrom = c.lag.lastMessageID
+}
+
+// TakeResyncNotice returns the encoded resync notice for a lagging client and
+// clears the lag, or nil if the client is keeping up.
+func (c *Client) TakeResyncNotice() []byte {
+	c.lag.mu.Lock()
+	defer c.lag.mu.Unlock()
+	if !c.lag.lagging {
+		return nil
+	}
+
+	ev, err := NewEvent(c.BotSpaceID, EventResync, ResyncNotice{Missed: c.lag.missed, ResyncFrom: c.lag.resyncFrom})
+	if err != nil {
+		return nil
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return nil
+	}
+	c.lag.lagging = false
+	c.lag.missed = 0
+	return data
+}
+
+// DropReason explains why the hub dropped the client, if it did.
+func (c *Client) DropReason() string {
+	c.lag.mu.Lock()
+	defer c.lag.mu.Unlock()
+	return c.lag.dropReason
+}
+
+// overflow applies the hub's slow consumer policy to a client whose buffer
+// is full.
+func (h *Hub) overflow(client *Client) {
+	policy := h.slowConsumerPolicy
+	if policy == SlowConsumerCoalesce && client.Events == nil {
+		policy = SlowConsumerDisconnect
+	}
+	droppedEvents.WithLabelValues(client.BotSpaceID).Inc()
+
+	client.lag.mu.Lock()
+	alreadyDropped := client.lag.dropReason != ""
+	if policy != SlowConsumerCoalesce {
+		client.lag.dropReason = "send buffer full"
+	}
+	client.lag.mu.Unlock()
+	if alreadyDropped {
+		return
+	}
+	slowConsumerEvents.WithLabelValues(client.BotSpaceID, policy).Inc()
+
+	switch policy {
+	case SlowConsumerCoalesce:
+		client.startLagging()
+	case SlowConsumerDisconnect:
+		if client.Conn != nil {
+			msg := websocket.FormatCloseMessage(CloseSlowConsumer, "send buffer full; resync and reconnect")
+			if err := client.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait)); err != nil {
+				h.log.WithError(err).Debug("failed to write slow consumer close message")
+			}
+		}
+		go h.Unregister(client)
+	default:
+		go h.Unregister(client)
+	}
+}
@@ -1,22 +1,29 @@
 import type {
   ArtifactListResponse,
+  ArtifactSearchResponse,
   AuthResponse,
   BotSpace,
   Bot,
   BotSkill,
   BotStatus,
   CreateBotSpaceRequest,
+  CreateTaskRequest,
+  CreateTaskResponse,
   InviteCode,
   JoinBotSpaceRequest,
   LoginRequest,
   Message,
   MessageListResponse,
+  MessageRecallResponse,
+  MessageSearchResponse,
   OverallResponse,
   PostMessageRequest,
   SignupRequest,
+  SkillMatch,
   SpaceMemberWithUser,
   SpaceTask,
   Summary,
+  TaskFilter,
   UpdateBotSpaceRequest,
   User,
 } from "./types";
@@ -123,6 +130,20 @@ export const listMessages = (spaceId: string, before?: string, limit?: number) =
     }),
   );
 
+export const searchMessages = (spaceId: string, q: string, limit?: number, offset?: number) =>
+  request<MessageSearchResponse>(
+    withQuery(`/bot-spaces/${spaceId}/messages/search`, {
+      q,
+      limit: limit?.toString(),
+      offset: offset?.toString(),
+    }),
+  );
+
+export const recallMessages = (spaceId: string, q: string, limit?: number) =>
+  request<MessageRecallResponse>(
+    withQuery(`/bot-spaces/${spaceId}/messages/recall`, { q, limit: limit?.toString() }),
+  );
+
 export const getMessagesSince = (spaceId: string, messageId: string, limit?: number) =>
   request<MessageListResponse>(
     withQuery(`/bot-spaces/${spaceId}/messages/since/${messageId}`, {
@@ -160,9 +181,38 @@ export const listStatuses = (spaceId: string) =>
 export const listSkills = (spaceId: string) =>
   request<BotSkill[]>(`/bot-spaces/${spaceId}/skills`);
 
+export const searchSkills = (spaceId: string, q: string, limit?: number) =>
+  request<SkillMatch[]>(
+    withQuery(`/bot-spaces/${spaceId}/skills/search`, { q, limit: limit?.toString() }),
+  );
+
 // Tasks
-export const listTasks = (spaceId: string) =>
-  request<SpaceTask[]>(`/bot-spaces/${spaceId}/tasks`);
+export const listTasks = (spaceId: string, filter: TaskFilter = {}) =>
+  request<SpaceTask[]>(
+    withQuery(`/bot-spaces/${spaceId}/tasks`, {
+      status: filter.status,
+      botId: filter.botId,
+      parentTaskId: filter.parentTaskId,
+    }),
+  );
+
+export const createTask = (spaceId: string, data: CreateTaskRequest) =>
+  request<CreateTaskResponse>(`/bot-spaces/${spaceId}/tasks`, {
+    method: "POST",
+    body: JSON.stringify(data),
+  });
+
+export const assignTask = (spaceId: string, taskId: string, botId: string) =>
+  request<SpaceTask>(`/bot-spaces/${spaceId}/tasks/${taskId}/assign`, {
+    method: "POST",
+    body: JSON.stringify({ botId }),
+  });
+
+export const cancelTask = (spaceId: string, taskId: string, note?: string) =>
+  request<SpaceTask>(`/bot-spaces/${spaceId}/tasks/${taskId}/cancel`, {
+    method: "POST",
+    body: JSON.stringify({ note }),
+  });
 
 // Summary
 export const getSummary = (spaceId: string) =>
@@ -191,6 +241,15 @@ export const listArtifacts = (spaceId: string, before?: string, limit?: number)
     withQuery(`/bot-spaces/${spaceId}/artifacts`, { before, limit: limit?.toString() }),
   );
 
+export const searchArtifacts = (spaceId: string, q: string, limit?: number, offset?: number) =>
+  request<ArtifactSearchResponse>(
+    withQuery(`/bot-spaces/${spaceId}/artifacts/search`, {
+      q,
+      limit: limit?.toString(),
+      offset: offset?.toString(),
+    }),
+  );
+
 // Overall
 export const getOverall = (spaceId: string) =>
   request<OverallResponse>(`/bot-spaces/${spaceId}/overall`);
@@ -78,6 +78,10 @@ export interface BotSkill {
   updatedAt: string;
 }
 
+export interface SkillMatch extends BotSkill {
+  distance: number;
+}
+
 export interface Summary {
   id: string;
   botSpaceId: string;
@@ -94,12 +98,46 @@ export interface SpaceTask {
   description: string;
   status: string;
   botId: string | null;
-  createdByBotId: string;
+  createdByType: "bot" | "user";
+  createdById: string;
+  requiredSkills: string[] | null;
   completedAt: string | null;
   createdAt: string;
   updatedAt: string;
 }
 
+export interface TaskSuggestion {
+  botId: string;
+  botName: string;
+  matchedSkills: string[];
+  score: number;
+  load: number;
+  presence: 'online' | 'idle';
+}
+
+export interface CreateTaskResponse extends SpaceTask {
+  suggestions?: TaskSuggestion[];
+}
+
+export interface TaskFilter {
+  status?: string;
+  botId?: string;
+  parentTaskId?: string;
+}
+
+export interface CreateTaskRequest {
+  name: string;
+  description: string;
+  botId?: string;
+  dependsOn?: string[];
+  parentTaskId?: string;
+  priority?: number;
+  dueAt?: string;
+  requiresReview?: boolean;
+  requiredSkills?: string[];
+  autoAssign?: boolean;
+}
+
 export interface Artifact {
   id: string;
   botSpaceId: string;
@@ -117,6 +155,17 @@ export interface ArtifactListResponse {
   hasMore: boolean;
 }
 
+export interface ArtifactMatch extends Artifact {
+  rank: number;
+  snippet: string;
+}
+
+export interface ArtifactSearchResponse {
+  artifacts: ArtifactMatch[];
+  count: number;
+  hasMore: boolean;
+}
+
 export interface InviteCode {
   id: string;
   botSpaceId: string;
@@ -169,6 +218,26 @@ export interface MessageListResponse {
   hasMore: boolean;
 }
 
+export interface MessageMatch extends Message {
+  rank: number;
+  snippet: string;
+}
+
+export interface RecalledMessage extends Message {
+  distance: number;
+}
+
+export interface MessageRecallResponse {
+  messages: RecalledMessage[];
+  count: number;
+}
+
+export interface MessageSearchResponse {
+  messages: MessageMatch[];
+  count: number;
+  hasMore: boolean;
+}
+
 export interface OverallResponse {
   messages: MessageListResponse;
   summary: Summary | null;
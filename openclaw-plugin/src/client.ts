@@ -5,6 +5,7 @@ import type {
   CsBotStatus,
   CsMessage,
   CsMessageListResponse,
+  CsMessageRecallResponse,
   CsSpaceTask,
 } from "./types.js";
 
@@ -125,6 +126,24 @@ export class ClawSwarmClient {
     return res.json();
   }
 
+  async recallMessages(
+    botSpaceId: string,
+    query: string,
+    opts?: { limit?: number },
+  ): Promise<CsMessageRecallResponse> {
+    const params = new URLSearchParams({ q: query });
+    if (opts?.limit != null) params.set("limit", String(opts.limit));
+    const url = `${this.apiUrl}/bot-spaces/${botSpaceId}/messages/recall?${params}`;
+    const res = await fetch(url, {
+      headers: { Authorization: `Bearer ${this.token}` },
+    });
+    if (!res.ok) {
+      const text = await res.text();
+      throw new Error(`recallMessages failed (${res.status}): ${text}`);
+    }
+    return res.json();
+  }
+
   // ── Statuses ─────────────────────────────────────────────────────
 
   async listStatuses(botSpaceId: string): Promise<CsBotStatus[]> {
@@ -4,7 +4,7 @@ export interface CsMessage {
   botSpaceId: string;
   senderId: string;
   senderName: string;
-  senderType: "bot" | "user";
+  senderType: "bot" | "user" | "system";
   content: string;
   createdAt: string;
 }
@@ -16,6 +16,17 @@ export interface CsMessageListResponse {
   hasMore: boolean;
 }
 
+/** A message returned by semantic recall, with its distance from the query. */
+export interface CsRecalledMessage extends CsMessage {
+  distance: number;
+}
+
+/** Response from the message recall endpoint, closest first. */
+export interface CsMessageRecallResponse {
+  messages: CsRecalledMessage[];
+  count: number;
+}
+
 /** POST body for bot registration. */
 export interface CsBotRegistrationRequest {
   joinCode: string;
@@ -70,7 +81,9 @@ export interface CsSpaceTask {
   description: string;
   status: "available" | "in_progress" | "completed" | "blocked";
   botId: string | null;
-  createdByBotId: string;
+  createdByType: "bot" | "user";
+  createdById: string;
+  requiredSkills: string[] | null;
   completedAt: string | null;
   createdAt: string;
   updatedAt: string;
@@ -0,0 +1,10 @@
+-- Hub payloads too large for a single NOTIFY are stored here and broadcast by
+-- reference. Rows are short-lived; the API prunes them after a few minutes.
+CREATE TABLE broadcast_payloads (
+    id UUID PRIMARY KEY,
+    bot_space_id UUID NOT NULL,
+    data TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_broadcast_payloads_created ON broadcast_payloads (created_at);
@@ -0,0 +1,5 @@
+-- Per-space event sequence numbers shared by every API replica.
+CREATE TABLE space_event_seqs (
+    bot_space_id UUID PRIMARY KEY REFERENCES bot_spaces (id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL
+);
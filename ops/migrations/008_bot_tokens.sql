@@ -0,0 +1,19 @@
+-- Issued bot credentials, keyed by the token's jti. A bot token is only
+-- accepted while its row exists, has not expired and has not been revoked.
+CREATE TABLE bot_tokens (
+    id UUID PRIMARY KEY,
+    bot_id UUID NOT NULL REFERENCES bots (id) ON DELETE CASCADE,
+    bot_space_id UUID NOT NULL REFERENCES bot_spaces (id) ON DELETE CASCADE,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_bot_tokens_bot_id ON bot_tokens (bot_id);
+CREATE INDEX idx_bot_tokens_bot_space_id ON bot_tokens (bot_space_id);
+CREATE INDEX idx_bot_tokens_expires_at ON bot_tokens (expires_at);
+
+-- Bot tokens issued before this time are rejected. It covers tokens without
+-- a jti, which have no bot_tokens row to revoke, and is set whenever a bot's
+-- tokens are revoked or a legacy token is exchanged.
+ALTER TABLE bots ADD COLUMN tokens_revoked_before TIMESTAMPTZ;
@@ -0,0 +1,19 @@
+-- Every task write is conditional on the version it read, so concurrent
+-- claims of the same task have exactly one winner.
+ALTER TABLE space_tasks ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Earlier races could leave a bot with more than one task in progress. Keep
+-- the most recently updated one and block the rest before enforcing one
+-- active task per bot.
+UPDATE space_tasks SET status = 'blocked', updated_at = now()
+WHERE status = 'in_progress' AND id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (PARTITION BY bot_space_id, bot_id ORDER BY updated_at DESC) AS rn
+        FROM space_tasks
+        WHERE status = 'in_progress' AND bot_id IS NOT NULL
+    ) ranked
+    WHERE rn > 1
+);
+
+CREATE UNIQUE INDEX idx_space_tasks_one_active_per_bot ON space_tasks (bot_space_id, bot_id)
+WHERE status = 'in_progress';
@@ -0,0 +1,9 @@
+-- A task with unfinished dependencies waits in the 'waiting' status and is
+-- made available once every task it depends on is completed.
+CREATE TABLE space_task_dependencies (
+    task_id UUID NOT NULL REFERENCES space_tasks (id) ON DELETE CASCADE,
+    depends_on_task_id UUID NOT NULL REFERENCES space_tasks (id) ON DELETE CASCADE,
+    PRIMARY KEY (task_id, depends_on_task_id)
+);
+
+CREATE INDEX idx_space_task_dependencies_depends_on ON space_task_dependencies (depends_on_task_id);
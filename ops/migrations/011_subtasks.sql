@@ -0,0 +1,5 @@
+-- Tasks may be broken down into subtasks. Subtasks proposed by a worker bot
+-- wait in 'proposed' until the manager approves or rejects them.
+ALTER TABLE space_tasks ADD COLUMN parent_task_id UUID REFERENCES space_tasks (id) ON DELETE CASCADE;
+
+CREATE INDEX idx_space_tasks_parent ON space_tasks (parent_task_id) WHERE parent_task_id IS NOT NULL;
@@ -0,0 +1,6 @@
+-- Higher priority tasks are handed out first; among equal priorities the
+-- earliest due date wins, then the oldest task.
+ALTER TABLE space_tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE space_tasks ADD COLUMN due_at TIMESTAMPTZ;
+
+CREATE INDEX idx_space_tasks_pick_order ON space_tasks (bot_space_id, status, priority DESC, due_at ASC NULLS LAST, created_at ASC);
@@ -0,0 +1,12 @@
+-- Tasks in progress are leased to their bot. The bot renews the lease with
+-- heartbeats; once it lapses the task is released back to 'available'.
+ALTER TABLE space_tasks ADD COLUMN lease_expires_at TIMESTAMPTZ;
+
+-- Give tasks already in progress a grace period to start heartbeating.
+UPDATE space_tasks SET lease_expires_at = now() + interval '1 hour' WHERE status = 'in_progress';
+
+CREATE INDEX idx_space_tasks_lease ON space_tasks (lease_expires_at) WHERE status = 'in_progress';
+
+-- The server posts system messages, e.g. when it releases a task.
+ALTER TABLE messages DROP CONSTRAINT messages_sender_type_check;
+ALTER TABLE messages ADD CONSTRAINT messages_sender_type_check CHECK (sender_type IN ('bot', 'user', 'system'));
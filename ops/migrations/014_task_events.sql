@@ -0,0 +1,18 @@
+-- What happened to a task, with whatever the bot reported alongside it: the
+-- result of completed work, why a task is blocked, and artifacts it produced.
+-- Events are written with both the application's and the database's clock,
+-- so seq rather than created_at gives their order.
+CREATE TABLE task_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    seq BIGSERIAL NOT NULL,
+    task_id UUID NOT NULL REFERENCES space_tasks (id) ON DELETE CASCADE,
+    bot_space_id UUID NOT NULL REFERENCES bot_spaces (id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    actor_id UUID,
+    result TEXT,
+    reason TEXT,
+    artifact_ids UUID[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_task_events_task ON task_events (task_id, seq);
@@ -0,0 +1,14 @@
+-- task_events becomes the audit log of every task transition. Actors may be
+-- bots, users or the server itself.
+ALTER TABLE task_events ADD COLUMN actor_type TEXT NOT NULL DEFAULT 'bot' CHECK (actor_type IN ('bot', 'user', 'system'));
+ALTER TABLE task_events ADD COLUMN from_status TEXT;
+ALTER TABLE task_events ADD COLUMN to_status TEXT;
+ALTER TABLE task_events ADD COLUMN note TEXT;
+
+-- Events recorded so far were completions and blocks of tasks in progress.
+UPDATE task_events SET from_status = 'in_progress', to_status = type;
+ALTER TABLE task_events ALTER COLUMN to_status SET NOT NULL;
+
+-- Start the history of existing tasks with their creation.
+INSERT INTO task_events (task_id, bot_space_id, type, actor_type, actor_id, to_status, created_at)
+SELECT id, bot_space_id, 'created', 'bot', created_by_bot_id, status, created_at FROM space_tasks;
@@ -0,0 +1,6 @@
+-- Tasks which require review go to 'in_review' when their bot completes them
+-- and only count as completed once a manager bot or a member approves.
+ALTER TABLE space_tasks ADD COLUMN requires_review BOOLEAN NOT NULL DEFAULT false;
+
+-- The default for new tasks in the space.
+ALTER TABLE bot_spaces ADD COLUMN requires_task_review BOOLEAN NOT NULL DEFAULT false;
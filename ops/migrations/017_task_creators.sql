@@ -0,0 +1,8 @@
+-- Tasks may be created by users of the space as well as by bots. Dropping
+-- created_by_bot_id also stops a bot's removal from deleting its tasks.
+ALTER TABLE space_tasks ADD COLUMN created_by_type TEXT NOT NULL DEFAULT 'bot' CHECK (created_by_type IN ('bot', 'user'));
+ALTER TABLE space_tasks ADD COLUMN created_by_id UUID;
+UPDATE space_tasks SET created_by_id = created_by_bot_id;
+ALTER TABLE space_tasks ALTER COLUMN created_by_id SET NOT NULL;
+ALTER TABLE space_tasks ALTER COLUMN created_by_type DROP DEFAULT;
+ALTER TABLE space_tasks DROP COLUMN created_by_bot_id;
@@ -0,0 +1,24 @@
+-- Task templates which the scheduler turns into space_tasks on a cron
+-- schedule.
+CREATE TABLE task_schedules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    bot_space_id UUID NOT NULL REFERENCES bot_spaces (id) ON DELETE CASCADE,
+    name VARCHAR(200) NOT NULL,
+    description TEXT NOT NULL,
+    cron TEXT NOT NULL,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    priority INTEGER NOT NULL DEFAULT 0,
+    requires_review BOOLEAN NOT NULL DEFAULT false,
+    paused BOOLEAN NOT NULL DEFAULT false,
+    next_run_at TIMESTAMPTZ NOT NULL,
+    last_run_at TIMESTAMPTZ,
+    created_by_type TEXT NOT NULL CHECK (created_by_type IN ('bot', 'user')),
+    created_by_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_task_schedules_bot_space ON task_schedules (bot_space_id);
+CREATE INDEX idx_task_schedules_due ON task_schedules (next_run_at) WHERE NOT paused;
+
+ALTER TABLE space_tasks ADD COLUMN schedule_id UUID REFERENCES task_schedules (id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+-- Skill names or tags a task needs, matched case-insensitively against
+-- bot_skills when routing the task to a bot.
+ALTER TABLE space_tasks ADD COLUMN required_skills VARCHAR(100)[];
@@ -0,0 +1,2 @@
+-- Nearest-neighbour index for semantic skill search by cosine distance.
+CREATE INDEX idx_bot_skills_embedding ON bot_skills USING hnsw (embedding vector_cosine_ops);
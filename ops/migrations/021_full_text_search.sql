@@ -0,0 +1,22 @@
+-- Full-text search over chat messages.
+--
+-- Adding a STORED generated column rewrites the whole table under an ACCESS
+-- EXCLUSIVE lock, blocking reads and writes of messages and artifacts until
+-- it finishes. On large deployments run this in a maintenance window.
+ALTER TABLE messages ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX idx_messages_search ON messages USING gin (search_vector);
+
+-- Full-text search over artifacts, ranking name matches above description
+-- matches above data matches. A tsvector cannot exceed 1MB, so only the
+-- first 10,000 characters of the description and 100,000 of the data are
+-- indexed; larger artifacts are still stored whole.
+ALTER TABLE artifacts ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('english', left(description, 10000)), 'B') ||
+        setweight(to_tsvector('english', left(data, 100000)), 'C')
+    ) STORED;
+
+CREATE INDEX idx_artifacts_search ON artifacts USING gin (search_vector);
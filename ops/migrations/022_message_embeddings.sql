@@ -0,0 +1,13 @@
+-- Embeddings of message content for semantic recall. The API embeds new
+-- messages in the background, so recent ones may briefly have none.
+ALTER TABLE messages ADD COLUMN embedding vector(1536);
+
+-- embedded_at is set once a message has been embedded, leaving embedding
+-- NULL if the message had nothing to embed. Failed attempts are counted so
+-- the embedder can retry a message later and give up on it after a few.
+ALTER TABLE messages ADD COLUMN embedded_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN embed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN embed_failed_at TIMESTAMPTZ;
+
+CREATE INDEX idx_messages_embedding ON messages USING hnsw (embedding vector_cosine_ops);
+CREATE INDEX idx_messages_unembedded ON messages (created_at) WHERE embedded_at IS NULL;